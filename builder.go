@@ -0,0 +1,87 @@
+package sabertooth
+
+// Builder builds a Sabertooth with options and per motor settings using
+// chained method calls. Errors in the settings are reported by Build.
+//
+//	st, err := sabertooth.NewBuilder().
+//		Port("/dev/ttyACM0").
+//		InvertMotor(2).
+//		SpeedLimit(1, 0.5).
+//		Build()
+type Builder struct {
+	address  byte
+	portName string
+	opts     []Option
+	channels []func(*Sabertooth) error
+}
+
+// NewBuilder returns a Builder for a device with the default address 128
+func NewBuilder() *Builder {
+	return &Builder{address: 128}
+}
+
+// Address sets the address of the device
+func (b *Builder) Address(address byte) *Builder {
+	b.address = address
+	return b
+}
+
+// Port sets the serial port the device is attached to
+func (b *Builder) Port(portName string) *Builder {
+	b.portName = portName
+	return b
+}
+
+// BaudRate sets the baud rate of the serial port
+func (b *Builder) BaudRate(baud int) *Builder {
+	return b.With(WithBaudRate(baud))
+}
+
+// With adds options
+func (b *Builder) With(opts ...Option) *Builder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// InvertMotor inverts the direction of a motor, see SetInverted
+func (b *Builder) InvertMotor(motor int) *Builder {
+	b.channels = append(b.channels, func(st *Sabertooth) error {
+		st.SetInverted(motor, true)
+		return nil
+	})
+	return b
+}
+
+// SpeedLimit limits the speed of a motor, see SetSpeedLimit
+func (b *Builder) SpeedLimit(motor int, max float64) *Builder {
+	b.channels = append(b.channels, func(st *Sabertooth) error {
+		return st.SetSpeedLimit(motor, max)
+	})
+	return b
+}
+
+// RPMCalibration sets the RPM of a motor at full speed, see
+// SetRPMCalibration
+func (b *Builder) RPMCalibration(motor int, maxRPM float64) *Builder {
+	b.channels = append(b.channels, func(st *Sabertooth) error {
+		st.SetRPMCalibration(motor, maxRPM)
+		return nil
+	})
+	return b
+}
+
+// Build creates the Sabertooth. The serial port is opened on first use as
+// with NewSabertooth.
+func (b *Builder) Build() (*Sabertooth, error) {
+	st, err := NewSabertooth(b.address, b.portName, b.opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, configure := range b.channels {
+		err = configure(st)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,107 @@
+package sabertooth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bus is a set of devices at different packet serial addresses sharing a
+// serial port, for commands that address more than one of them
+type Bus struct {
+	devices []*Sabertooth
+}
+
+// NewBus creates a Bus from devices created with NewSabertoothWithPort on
+// the same serial port. Commands sent through the Bus hold the locks of all
+// its devices, so they never come between the write and the reply of a
+// command sent through one of the devices.
+func NewBus(devices ...*Sabertooth) (*Bus, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no devices")
+	}
+	devices = append([]*Sabertooth(nil), devices...)
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].address < devices[j].address
+	})
+	for i, st := range devices {
+		if !st.externalPort || st.port != devices[0].port {
+			return nil, errors.New("devices must share a port given to NewSabertoothWithPort")
+		}
+		if i > 0 && st.address == devices[i-1].address {
+			return nil, fmt.Errorf("duplicate address %d", st.address)
+		}
+	}
+	return &Bus{devices}, nil
+}
+
+// MotorCommand is a motor speed for a device on a Bus. Speed is between -1
+// and 1 inclusive.
+type MotorCommand struct {
+	Motor int
+	Speed float64
+}
+
+// SyncMotors sets a motor of several devices at once, keyed by device
+// address. All frames are encoded first and then sent in a single write,
+// in address order, to keep the skew between the devices small. The
+// devices still start one frame time apart, as a shared bus can not
+// deliver them simultaneously. As with MotorRaw, the host side settings of
+// the devices, such as inversion and soft start, are not applied.
+func (b *Bus) SyncMotors(cmds map[byte]MotorCommand) error {
+	for address, cmd := range cmds {
+		if cmd.Speed < -1 || cmd.Speed > 1 {
+			return errors.New("value out of range")
+		}
+		if b.device(address) == nil {
+			return fmt.Errorf("no device at address %d", address)
+		}
+	}
+	// Devices from Get on the same port share a lock
+	locked := make(map[*sync.Mutex]bool)
+	for _, st := range b.devices {
+		if !locked[st.mu] {
+			st.mu.Lock()
+			defer st.mu.Unlock()
+			locked[st.mu] = true
+		}
+	}
+	var frames []byte
+	for _, st := range b.devices {
+		cmd, ok := cmds[st.address]
+		if !ok {
+			continue
+		}
+		err := st.motorAllowed()
+		if err != nil {
+			return fmt.Errorf("address %d: %w", st.address, err)
+		}
+		frames = AppendSetCommand(frames, st.address, CmdSetValue, 'M', byte(cmd.Motor), Denormalize(cmd.Speed))
+	}
+	if len(frames) == 0 {
+		return nil
+	}
+	err := b.devices[0].write(frames)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, st := range b.devices {
+		if _, ok := cmds[st.address]; ok {
+			st.lastCommand = now
+		}
+	}
+	return nil
+}
+
+// device returns the device at address, or nil
+func (b *Bus) device(address byte) *Sabertooth {
+	for _, st := range b.devices {
+		if st.address == address {
+			return st
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package sabertooth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newBus(t *testing.T, port *fakePort, addresses ...byte) (*Bus, []*Sabertooth) {
+	t.Helper()
+	var devices []*Sabertooth
+	for _, address := range addresses {
+		devices = append(devices, NewSabertoothWithPort(address, port))
+	}
+	bus, err := NewBus(devices...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bus, devices
+}
+
+func TestSyncMotors(t *testing.T) {
+	port := &fakePort{}
+	bus, _ := newBus(t, port, 130, 128, 129)
+	err := bus.SyncMotors(map[byte]MotorCommand{
+		130: {1, -1},
+		128: {2, 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frames []byte
+	frames = append(frames, setCommand(128, CmdSetValue, 'M', 2, 1024)...)
+	frames = append(frames, setCommand(130, CmdSetValue, 'M', 1, -2047)...)
+	// A single write, in address order
+	expectWrites(t, port, frames)
+}
+
+func TestSyncMotorsInvalid(t *testing.T) {
+	port := &fakePort{}
+	bus, devices := newBus(t, port, 128, 129)
+	if err := bus.SyncMotors(map[byte]MotorCommand{128: {1, 0.5}, 129: {1, 1.5}}); err == nil {
+		t.Error("speed 1.5 accepted")
+	}
+	if err := bus.SyncMotors(map[byte]MotorCommand{128: {1, 0.5}, 131: {1, 0.5}}); err == nil {
+		t.Error("unknown address accepted")
+	}
+	err := devices[1].Disable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(port.written())
+	err = bus.SyncMotors(map[byte]MotorCommand{128: {1, 0.5}, 129: {1, 0.5}})
+	if !errors.Is(err, ErrDisabled) {
+		t.Errorf("got %v, want ErrDisabled", err)
+	}
+	if m := len(port.written()); m != n {
+		t.Errorf("%d writes with an invalid command", m-n)
+	}
+}
+
+func TestSyncMotorsWaitsForTransaction(t *testing.T) {
+	port := &fakePort{}
+	bus, devices := newBus(t, port, 128, 129)
+	devices[0].SetReadTimeout(100 * time.Millisecond)
+	start := time.Now()
+	go devices[0].Battery()
+	time.Sleep(20 * time.Millisecond)
+	err := bus.SyncMotors(map[byte]MotorCommand{129: {1, 0.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The battery read holds the lock until its read timeout
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("SyncMotors wrote after %v while a reply was awaited", elapsed)
+	}
+	expectWrites(t, port,
+		getCommand(128, CmdGetBattery, 'M', 1),
+		setCommand(129, CmdSetValue, 'M', 1, 1024),
+	)
+}
+
+func TestNewBusInvalid(t *testing.T) {
+	port, other := &fakePort{}, &fakePort{}
+	_, restore := fakeOpen()
+	defer restore()
+	named, err := NewSabertooth(129, "/dev/ttyUSB0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		devices []*Sabertooth
+	}{
+		{"no devices", nil},
+		{"different ports", []*Sabertooth{NewSabertoothWithPort(128, port), NewSabertoothWithPort(129, other)}},
+		{"duplicate address", []*Sabertooth{NewSabertoothWithPort(128, port), NewSabertoothWithPort(128, port)}},
+		{"named port", []*Sabertooth{named}},
+	}
+	for _, test := range tests {
+		if _, err := NewBus(test.devices...); err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package sabertooth
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// StartCapture starts writing all bytes sent to and received from the
+// device to w, one frame per line:
+//
+//	2020-10-15T12:00:00.123456789Z tx 802910394d014e
+//
+// where the direction is tx or rx. A capture can be played back with
+// ReplayCapture. Errors writing to w are ignored.
+func (st *Sabertooth) StartCapture(w io.Writer) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.capture = w
+}
+
+// StopCapture stops a capture started with StartCapture
+func (st *Sabertooth) StopCapture() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.capture = nil
+}
+
+// captureFrame writes a frame to the capture, if any. The caller must hold
+// st.mu.
+func (st *Sabertooth) captureFrame(direction string, frame []byte) {
+	if st.capture == nil {
+		return
+	}
+	fmt.Fprintf(st.capture, "%s %s %x\n", time.Now().UTC().Format(time.RFC3339Nano), direction, frame)
+}
+
+// ReplayCapture reads a capture written by StartCapture and returns a
+// serial.Port that plays back the received bytes, for example for use
+// with NewSabertoothWithPort in a test. Each Read returns the received
+// bytes in the order they were captured, without their original timing.
+// Writes are accepted and discarded. Once all received bytes are read,
+// Read reports a timeout.
+func ReplayCapture(r io.Reader) (serial.Port, error) {
+	port := &replayPort{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[1] != "rx" || len(fields) < 3 {
+			continue
+		}
+		frame, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		port.rx = append(port.rx, frame...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return port, nil
+}
+
+// replayPort is a serial.Port playing back captured bytes
+type replayPort struct {
+	rx     []byte
+	closed bool
+}
+
+func (p *replayPort) Read(b []byte) (int, error) {
+	if p.closed {
+		return 0, errors.New("port closed")
+	}
+	n := copy(b, p.rx)
+	p.rx = p.rx[n:]
+	return n, nil
+}
+
+func (p *replayPort) Write(b []byte) (int, error) {
+	if p.closed {
+		return 0, errors.New("port closed")
+	}
+	return len(b), nil
+}
+
+func (p *replayPort) SetMode(mode *serial.Mode) error {
+	return nil
+}
+
+func (p *replayPort) ResetInputBuffer() error {
+	return nil
+}
+
+func (p *replayPort) ResetOutputBuffer() error {
+	return nil
+}
+
+func (p *replayPort) SetDTR(dtr bool) error {
+	return nil
+}
+
+func (p *replayPort) SetRTS(rts bool) error {
+	return nil
+}
+
+func (p *replayPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+
+func (p *replayPort) SetReadTimeout(t time.Duration) error {
+	return nil
+}
+
+func (p *replayPort) Close() error {
+	p.closed = true
+	return nil
+}
@@ -0,0 +1,74 @@
+package sabertooth
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureReplay(t *testing.T) {
+	st, port := newFake()
+	port.respond = telemetryDevice
+	var capture bytes.Buffer
+	st.StartCapture(&capture)
+	bat, err := st.Battery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Motor(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.StopCapture()
+	_, err = st.Current(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(capture.String(), "\n"), "\n")
+	want := []string{
+		fmt.Sprintf("tx %x", getCommand(128, CmdGetBattery, 'M', 1)),
+		fmt.Sprintf("rx %x", reply(128, CmdGetBattery, 245, 'M', 1)),
+		fmt.Sprintf("tx %x", setCommand(128, CmdSetValue, 'M', 1, 1024)),
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("captured %q, want %d lines", lines, len(want))
+	}
+	for i, line := range lines {
+		fields := strings.SplitN(line, " ", 2)
+		if _, err := time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+			t.Errorf("line %d: %v", i, err)
+		}
+		if len(fields) < 2 || fields[1] != want[i] {
+			t.Errorf("line %d: got %q, want %q after the time", i, line, want[i])
+		}
+	}
+
+	replay, err := ReplayCapture(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := NewSabertoothWithPort(128, replay)
+	replayed.SetReadTimeout(20 * time.Millisecond)
+	got, err := replayed.Battery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != bat {
+		t.Errorf("replayed battery %v, want %v", got, bat)
+	}
+	// The capture holds no more replies
+	_, err = replayed.Battery()
+	if err == nil {
+		t.Error("replay answered beyond the capture")
+	}
+}
+
+func TestReplayCaptureInvalid(t *testing.T) {
+	_, err := ReplayCapture(strings.NewReader("2020-10-15T12:00:00Z rx 80zz\n"))
+	if err == nil {
+		t.Error("invalid hex accepted")
+	}
+}
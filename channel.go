@@ -0,0 +1,186 @@
+package sabertooth
+
+import (
+	"errors"
+	"time"
+)
+
+// channel holds the host side state of a motor channel
+type channel struct {
+	lastSpeed float64
+	lastTime  time.Time
+	maxRPM    float64
+	inverted  bool
+	// speedLimit is the maximum speed, 0 means no limit
+	speedLimit float64
+}
+
+// channel returns the state of a motor channel, creating it on first use.
+// The caller must hold st.mu.
+func (st *Sabertooth) channel(motor int) *channel {
+	if st.channels == nil {
+		st.channels = make(map[int]*channel)
+	}
+	ch, ok := st.channels[motor]
+	if !ok {
+		ch = &channel{}
+		st.channels[motor] = ch
+	}
+	return ch
+}
+
+// stopped records that the motor has been stopped
+func (ch *channel) stopped() {
+	ch.lastSpeed = 0
+	ch.lastTime = time.Now()
+}
+
+// step returns the speed to write to move from the last written speed
+// toward target at rate per second, given the time now
+func (ch *channel) step(target, rate float64, now time.Time) float64 {
+	if ch.lastTime.IsZero() {
+		return ch.lastSpeed
+	}
+	maxDelta := rate * now.Sub(ch.lastTime).Seconds()
+	delta := target - ch.lastSpeed
+	if delta > maxDelta {
+		delta = maxDelta
+	} else if delta < -maxDelta {
+		delta = -maxDelta
+	}
+	return ch.lastSpeed + delta
+}
+
+// LastSpeed returns the last speed written to a motor, between -1 and 1
+// inclusive. With soft start enabled this is the ramped speed actually sent
+// to the device, not the speed requested from Motor.
+func (st *Sabertooth) LastSpeed(motor int) float64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.channel(motor).lastSpeed
+}
+
+// SetRPMCalibration sets the speed in RPM of a motor at full speed, which
+// MotorRPM uses to convert RPM to speed.
+func (st *Sabertooth) SetRPMCalibration(motor int, maxRPM float64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.channel(motor).maxRPM = maxRPM
+}
+
+// MotorRPM sets the speed of a motor in RPM. The RPM is converted to a
+// speed using the calibration set with SetRPMCalibration and clamped to the
+// calibrated maximum. This is open-loop control; the actual RPM depends on
+// the load.
+func (st *Sabertooth) MotorRPM(motor int, rpm float64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	maxRPM := st.channel(motor).maxRPM
+	if maxRPM <= 0 {
+		return errors.New("motor not calibrated")
+	}
+	speed := rpm / maxRPM
+	if speed > 1 {
+		speed = 1
+	} else if speed < -1 {
+		speed = -1
+	}
+	return st.motor(motor, speed)
+}
+
+// SetInverted sets whether the direction of a motor is inverted, for
+// motors wired to spin the wrong way. Motor negates the speed of an
+// inverted motor before sending it. LastSpeed still returns the speed as
+// requested, before inversion.
+func (st *Sabertooth) SetInverted(motor int, inverted bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.channel(motor).inverted = inverted
+}
+
+// SetSpeedLimit limits the speed of a motor by scaling the speed given to
+// Motor from -1 to 1 into -max to max. max must be greater than 0 and at
+// most 1; 1 removes the limit.
+func (st *Sabertooth) SetSpeedLimit(motor int, max float64) error {
+	if max <= 0 || max > 1 {
+		return errors.New("speed limit out of range")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.channel(motor).speedLimit = max
+	return nil
+}
+
+// ResetChannel restores the host side settings of a motor to their
+// defaults. It clears inversion, speed limit, RPM calibration and the last
+// speed. The motor itself is not commanded.
+func (st *Sabertooth) ResetChannel(motor int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.channels, motor)
+}
+
+// MotorAccel moves a motor toward the target speed by at most
+// maxDeltaPerCall from its last speed, and returns true once the target
+// is reached. Call it on each iteration of a control loop to accelerate
+// with a per call limit.
+func (st *Sabertooth) MotorAccel(motor int, target float64, maxDeltaPerCall float64) (bool, error) {
+	if target < -1 || target > 1 {
+		return false, errors.New("value out of range")
+	}
+	if maxDeltaPerCall <= 0 {
+		return false, errors.New("invalid acceleration")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	speed := st.channel(motor).lastSpeed
+	if target > speed+maxDeltaPerCall {
+		speed += maxDeltaPerCall
+	} else if target < speed-maxDeltaPerCall {
+		speed -= maxDeltaPerCall
+	} else {
+		speed = target
+	}
+	err := st.motor(motor, speed)
+	if err != nil {
+		return false, err
+	}
+	return st.channel(motor).lastSpeed == target, nil
+}
+
+// Nudge changes the speed of a motor by delta relative to its last speed,
+// clamped to -1 and 1, and returns the new speed. It is meant for trim
+// and button driven fine adjustments.
+func (st *Sabertooth) Nudge(motor int, delta float64) (float64, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	speed := st.channel(motor).lastSpeed + delta
+	if speed > 1 {
+		speed = 1
+	} else if speed < -1 {
+		speed = -1
+	}
+	err := st.motor(motor, speed)
+	if err != nil {
+		return st.channel(motor).lastSpeed, err
+	}
+	return speed, nil
+}
+
+// AppliedOutput reads the output the controller is actually applying to a
+// motor, between -1 and 1, which during a ramp of the controller differs
+// from the speed last written. For an inverted motor the output is negated
+// back, so that it compares with LastSpeed; the speed limit is not undone.
+func (st *Sabertooth) AppliedOutput(motor int) (float64, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	value, err := st.read(CmdGetValue, byte(Motor), byte(motor))
+	if err != nil {
+		return 0, err
+	}
+	output := Normalize(value)
+	if st.channel(motor).inverted {
+		output = -output
+	}
+	return output, nil
+}
@@ -0,0 +1,273 @@
+package sabertooth
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMotorRPM(t *testing.T) {
+	st, port := newFake()
+	if err := st.MotorRPM(1, 100); err == nil {
+		t.Error("uncalibrated motor accepted")
+	}
+	st.SetRPMCalibration(1, 3000)
+	tests := []struct {
+		rpm  float64
+		want int16
+	}{
+		{1500, 1024},
+		{-3000, -2047},
+		{6000, 2047},
+		{-4500, -2047},
+		{0, 0},
+	}
+	var want [][]byte
+	for _, test := range tests {
+		err := st.MotorRPM(1, test.rpm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, setCommand(128, CmdSetValue, 'M', 1, test.want))
+	}
+	expectWrites(t, port, want...)
+}
+
+func TestSetInverted(t *testing.T) {
+	st, port := newFake()
+	st.SetInverted(1, true)
+	for _, motor := range []int{1, 2} {
+		err := st.Motor(motor, 0.25)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 1, -512),
+		setCommand(128, CmdSetValue, 'M', 2, 512),
+	)
+	if speed := st.LastSpeed(1); speed != 0.25 {
+		t.Errorf("last speed of inverted motor %v, want 0.25", speed)
+	}
+}
+
+func TestSetSpeedLimit(t *testing.T) {
+	st, port := newFake()
+	err := st.SetSpeedLimit(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, speed := range []float64{1, -1} {
+		err = st.Motor(1, speed)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = st.Motor(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 1, 1024),
+		setCommand(128, CmdSetValue, 'M', 1, -1024),
+		setCommand(128, CmdSetValue, 'M', 2, 2047),
+	)
+	for _, max := range []float64{0, -0.5, 1.5} {
+		if err := st.SetSpeedLimit(1, max); err == nil {
+			t.Errorf("limit %v accepted", max)
+		}
+	}
+}
+
+func TestResetChannel(t *testing.T) {
+	st, port := newFake()
+	st.SetInverted(1, true)
+	st.SetRPMCalibration(1, 3000)
+	err := st.SetSpeedLimit(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Motor(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.ResetChannel(1)
+	if speed := st.LastSpeed(1); speed != 0 {
+		t.Errorf("last speed %v after reset, want 0", speed)
+	}
+	if err := st.MotorRPM(1, 1000); err == nil {
+		t.Error("RPM calibration kept after reset")
+	}
+	err = st.Motor(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 1, -1024),
+		setCommand(128, CmdSetValue, 'M', 1, 2047),
+	)
+}
+
+func TestMotorAccel(t *testing.T) {
+	st, port := newFake()
+	var want [][]byte
+	for i, raw := range []int16{512, 1024, 1535, 1638} {
+		reached, err := st.MotorAccel(1, 0.8, 0.25)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := i == 3; reached != last {
+			t.Errorf("step %d: reached %v, want %v", i, reached, last)
+		}
+		want = append(want, setCommand(128, CmdSetValue, 'M', 1, raw))
+	}
+	reached, err := st.MotorAccel(1, 0.7, 0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reached {
+		t.Error("target within one step not reached")
+	}
+	want = append(want, setCommand(128, CmdSetValue, 'M', 1, 1433))
+	expectWrites(t, port, want...)
+	if _, err := st.MotorAccel(1, 0, 0); err == nil {
+		t.Error("zero acceleration accepted")
+	}
+}
+
+func TestNudge(t *testing.T) {
+	st, port := newFake()
+	tests := []struct {
+		delta, want float64
+	}{
+		{0.25, 0.25},
+		{0.5, 0.75},
+		{0.5, 1},
+		{-3, -1},
+		{0.5, -0.5},
+	}
+	var want [][]byte
+	for _, test := range tests {
+		speed, err := st.Nudge(2, test.delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if speed != test.want {
+			t.Errorf("nudge by %v: speed %v, want %v", test.delta, speed, test.want)
+		}
+		want = append(want, setCommand(128, CmdSetValue, 'M', 2, Denormalize(test.want)))
+	}
+	expectWrites(t, port, want...)
+}
+
+func TestNudgeFails(t *testing.T) {
+	st, _ := newFake()
+	_, err := st.Nudge(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Disable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	speed, err := st.Nudge(1, 0.25)
+	if err != ErrDisabled {
+		t.Fatalf("got %v, want ErrDisabled", err)
+	}
+	if speed != 0.5 {
+		t.Errorf("speed %v after a failed nudge, want the last speed 0.5", speed)
+	}
+}
+
+func TestAppliedOutput(t *testing.T) {
+	st, port := newFake()
+	st.SetInverted(2, true)
+	port.queue(
+		reply(128, CmdGetValue, 1024, 'M', 1),
+		reply(128, CmdGetValue, 1024, 'M', 2),
+	)
+	output, err := st.AppliedOutput(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Normalize(1024); output != want {
+		t.Errorf("motor 1 output %v, want %v", output, want)
+	}
+	// The inverted motor reports its output as it was asked for
+	output, err = st.AppliedOutput(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := -Normalize(1024); output != want {
+		t.Errorf("inverted motor 2 output %v, want %v", output, want)
+	}
+	expectWrites(t, port,
+		getCommand(128, CmdGetValue, 'M', 1),
+		getCommand(128, CmdGetValue, 'M', 2),
+	)
+}
+
+func TestChannelStep(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		last, target, rate float64
+		elapsed            time.Duration
+		want               float64
+	}{
+		{0, 1, 2, 100 * time.Millisecond, 0.2},
+		{0.5, -1, 2, 100 * time.Millisecond, 0.3},
+		{0.9, 1, 2, 100 * time.Millisecond, 1},
+		{0.5, 0.5, 2, time.Second, 0.5},
+		{-1, 1, 0.5, 2 * time.Second, 0},
+	}
+	for _, test := range tests {
+		ch := &channel{lastSpeed: test.last, lastTime: start}
+		got := ch.step(test.target, test.rate, start.Add(test.elapsed))
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("step from %v to %v at %v/s after %v = %v, want %v",
+				test.last, test.target, test.rate, test.elapsed, got, test.want)
+		}
+	}
+	// Without a previous write the last speed is kept
+	ch := &channel{lastSpeed: 0.25}
+	if got := ch.step(1, 2, start); got != 0.25 {
+		t.Errorf("first step = %v, want 0.25", got)
+	}
+}
+
+func TestSoftStart(t *testing.T) {
+	const rate = 2.0
+	st, port := newFake(WithSoftStart(rate))
+	var speeds []float64
+	var times []time.Time
+	for i := 0; i < 8; i++ {
+		times = append(times, time.Now())
+		err := st.Motor(1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		speeds = append(speeds, st.LastSpeed(1))
+		time.Sleep(20 * time.Millisecond)
+	}
+	times = append(times, time.Now())
+	// The first call writes the last speed
+	if speeds[0] != 0 {
+		t.Errorf("first speed %v, want 0", speeds[0])
+	}
+	for i := 1; i < len(speeds); i++ {
+		step := speeds[i] - speeds[i-1]
+		// The ramp is timed between the calls, which lie within these times
+		maxStep := rate * times[i+1].Sub(times[i-1]).Seconds()
+		if step <= 0 || step > maxStep {
+			t.Errorf("step %d of %v, want above 0 and at most %v", i, step, maxStep)
+		}
+	}
+	if speeds[len(speeds)-1] >= 1 {
+		t.Errorf("reached %v within %v", speeds[len(speeds)-1], times[len(times)-1].Sub(times[0]))
+	}
+	var want [][]byte
+	for _, speed := range speeds {
+		want = append(want, setCommand(128, CmdSetValue, 'M', 1, Denormalize(speed)))
+	}
+	expectWrites(t, port, want...)
+}
@@ -0,0 +1,115 @@
+package sabertooth
+
+import (
+	"errors"
+	"fmt"
+
+	"go.bug.st/serial"
+)
+
+// Errors returned when a reply can not be decoded
+var (
+	ErrShortPacket       = errors.New("packet too short")
+	ErrUnexpectedCommand = errors.New("unexpected command type")
+	ErrInvalidByte       = errors.New("invalid byte in packet")
+	ErrHeaderChecksum    = errors.New("header checksum mismatch")
+	ErrDataChecksum      = errors.New("data checksum mismatch")
+)
+
+// ErrDisabled is returned by Motor when the outputs have been disabled
+var ErrDisabled = errors.New("outputs disabled")
+
+// ErrHeartbeatLost is returned by Motor while the heartbeat required with
+// RequireHeartbeat is missing
+var ErrHeartbeatLost = errors.New("heartbeat lost")
+
+// ErrVerifyMismatch is returned when a value read back with
+// WithVerifyWrites does not match the value set
+var ErrVerifyMismatch = errors.New("value read back does not match")
+
+// ErrPortClosed is returned for commands sent after Close
+var ErrPortClosed = errors.New("port closed")
+
+// ErrSimplifiedSerial is returned for commands that are not available in
+// simplified serial mode
+var ErrSimplifiedSerial = errors.New("not supported in simplified serial mode")
+
+// Errors returned by OpenPort when the port exists but can not be opened
+var (
+	// ErrPortBusy means another program has the port open. Close the
+	// other program and try again.
+	ErrPortBusy = errors.New("port in use by another program")
+	// ErrPermissionDenied means the user may not open the port. On Linux
+	// add the user to the dialout group, then log in again.
+	ErrPermissionDenied = errors.New("permission denied opening port")
+)
+
+// openError wraps errors from opening the port named portName, mapping
+// busy and permission errors to ErrPortBusy and ErrPermissionDenied
+func openError(portName string, err error) error {
+	var portErr *serial.PortError
+	if !errors.As(err, &portErr) {
+		return &TransportError{Op: "open", Err: err}
+	}
+	switch portErr.Code() {
+	case serial.PortBusy:
+		return fmt.Errorf("%s: %w", portName, ErrPortBusy)
+	case serial.PermissionDenied:
+		return fmt.Errorf("%s: %w", portName, ErrPermissionDenied)
+	}
+	return &TransportError{Op: "open", Err: err}
+}
+
+// ErrBaudMismatch is returned by OpenPort with WithVerifyBaudOnOpen when
+// the device does not reply validly at the configured baud rate. DetectBaud
+// finds the baud rate the controller uses.
+var ErrBaudMismatch = errors.New("baud rate mismatch")
+
+// ErrUnsupported is returned for values the model of the device does not
+// have
+var ErrUnsupported = errors.New("not supported by this model")
+
+// ProtocolError is returned when reading or decoding a reply fails. It
+// holds the raw frame involved, which may be partial.
+type ProtocolError struct {
+	Op    string
+	Frame []byte
+	Err   error
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%s [% x]: %v", e.Op, e.Frame, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when a complete reply is not received before the
+// read timeout expires. Received holds the bytes of the partial frame that
+// did arrive, which helps diagnosing for example a baud rate mismatch.
+type TimeoutError struct {
+	Expected int
+	Received []byte
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout: received %d of %d bytes [% x]", len(e.Received), e.Expected, e.Received)
+}
+
+// TransportError is returned when the serial port fails, for example when
+// a USB adapter is unplugged, as opposed to the device replying badly
+type TransportError struct {
+	Op  string
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,137 @@
+package sabertooth
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"go.bug.st/serial"
+)
+
+func TestTimeoutReportsPartialFrame(t *testing.T) {
+	st, port := newFake()
+	partial := reply(128, CmdGetBattery, 120, 'M', 1)[:5]
+	port.queue(partial)
+	_, err := st.Read(CmdGetBattery, 'M', 1)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a *TimeoutError", err)
+	}
+	if timeoutErr.Expected != ReplyLength {
+		t.Errorf("expected %d bytes, want %d", timeoutErr.Expected, ReplyLength)
+	}
+	if !bytes.Equal(timeoutErr.Received, partial) {
+		t.Errorf("received [% x], want [% x]", timeoutErr.Received, partial)
+	}
+	if !strings.Contains(err.Error(), "received 5 of 9 bytes [80 49 10 59 78]") {
+		t.Errorf("error %q does not describe the partial frame", err)
+	}
+}
+
+func TestTimeoutWithoutReply(t *testing.T) {
+	st, _ := newFake()
+	_, err := st.Read(CmdGetBattery, 'M', 1)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a *TimeoutError", err)
+	}
+	if len(timeoutErr.Received) != 0 {
+		t.Errorf("received [% x], want nothing", timeoutErr.Received)
+	}
+}
+
+func TestProtocolErrorCarriesFrame(t *testing.T) {
+	st, port := newFake()
+	bad := reply(128, CmdGetBattery, 120, 'M', 1)
+	bad[8] ^= 0x01
+	port.queue(bad)
+	_, err := st.Read(CmdGetBattery, 'M', 1)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("got %v, want a *ProtocolError", err)
+	}
+	if protocolErr.Op != "decode" {
+		t.Errorf("op %q, want decode", protocolErr.Op)
+	}
+	if !bytes.Equal(protocolErr.Frame, bad) {
+		t.Errorf("frame [% x], want [% x]", protocolErr.Frame, bad)
+	}
+	if !errors.Is(err, ErrDataChecksum) {
+		t.Errorf("%v does not unwrap to ErrDataChecksum", err)
+	}
+	want := "decode [80 49 10 59 78 00 4d 01 47]: data checksum mismatch"
+	if err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+}
+
+// failOpen makes ports opened by name fail with err. It returns a function
+// restoring the real open.
+func failOpen(err error) func() {
+	orig := openSerial
+	openSerial = func(name string, mode *serial.Mode) (serial.Port, error) {
+		return nil, err
+	}
+	return func() {
+		openSerial = orig
+	}
+}
+
+func TestOpenPortBusy(t *testing.T) {
+	// The zero PortError has the code PortBusy; the code can not be set
+	// from outside the serial package
+	defer failOpen(&serial.PortError{})()
+	st, _ := NewSabertooth(128, "/dev/ttyUSB0")
+	err := st.OpenPort()
+	if !errors.Is(err, ErrPortBusy) {
+		t.Fatalf("got %v, want ErrPortBusy", err)
+	}
+	if !strings.HasPrefix(err.Error(), "/dev/ttyUSB0: ") {
+		t.Errorf("error %q does not name the port", err)
+	}
+}
+
+func TestOpenPortPermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	f, err := ioutil.TempFile("", "sabertooth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	err = os.Chmod(f.Name(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := NewSabertooth(128, f.Name())
+	err = st.OpenPort()
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("got %v, want ErrPermissionDenied", err)
+	}
+}
+
+func TestOpenPortOtherErrors(t *testing.T) {
+	f, err := ioutil.TempFile("", "sabertooth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	// A file that is not a serial port, and a port that does not exist
+	for _, name := range []string{f.Name(), f.Name() + ".missing"} {
+		st, _ := NewSabertooth(128, name)
+		err = st.OpenPort()
+		var transportErr *TransportError
+		if !errors.As(err, &transportErr) || transportErr.Op != "open" {
+			t.Errorf("opening %s: got %v, want an open TransportError", name, err)
+		}
+		if errors.Is(err, ErrPortBusy) || errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("opening %s: got %v", name, err)
+		}
+	}
+}
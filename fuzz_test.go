@@ -0,0 +1,31 @@
+//go:build go1.18
+// +build go1.18
+
+package sabertooth
+
+import (
+	"testing"
+)
+
+func FuzzDecodePacket(f *testing.F) {
+	for _, v := range replyVectors {
+		f.Add(v.frame)
+		bad := append([]byte(nil), v.frame...)
+		bad[len(bad)-1] ^= 0x01
+		f.Add(bad)
+		f.Add(v.frame[:5])
+	}
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := decodePacket(data, DecodeValue)
+		if err != nil {
+			if packet != nil {
+				t.Errorf("packet %+v returned with error %v", *packet, err)
+			}
+			return
+		}
+		if len(data) < ReplyLength || data[1] != CmdReply {
+			t.Errorf("malformed frame [% x] decoded to %+v", data, *packet)
+		}
+	})
+}
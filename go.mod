@@ -2,4 +2,4 @@ module github.com/bjanders/sabertooth
 
 go 1.13
 
-require go.bug.st/serial v1.1.1
+require go.bug.st/serial v1.3.2
@@ -0,0 +1,186 @@
+package sabertooth
+
+import (
+	"log"
+	"time"
+)
+
+// Option configures optional behaviour of a Sabertooth. Options are passed
+// to NewSabertooth.
+type Option func(*Sabertooth)
+
+// WithTransientPort makes the Sabertooth open the serial port before each
+// command and close it again afterwards, freeing the device for other
+// processes between commands. Opening the port is slow compared to a
+// command, so this adds considerable latency to every Read and Motor call.
+// By default the port is opened on first use and kept open until Close is
+// called.
+func WithTransientPort(transient bool) Option {
+	return func(st *Sabertooth) {
+		st.transient = transient
+	}
+}
+
+// WithSoftStart limits how fast Motor changes the speed of a motor. On
+// each call Motor moves the motor from its last speed toward the requested
+// speed by at most ratePerSecond times the time elapsed since the previous
+// call for that motor. Motor must therefore be called repeatedly, for
+// example from a control loop, for the motor to reach the requested speed.
+// The first call for a motor writes its last speed, which is 0 unless
+// changed. LastSpeed returns the ramped speed actually written.
+func WithSoftStart(ratePerSecond float64) Option {
+	return func(st *Sabertooth) {
+		st.softStart = ratePerSecond
+	}
+}
+
+// WithBaudRate sets the baud rate used when opening the serial port. The
+// default is DefaultBaudRate.
+func WithBaudRate(baud int) Option {
+	return func(st *Sabertooth) {
+		st.baudRate = baud
+	}
+}
+
+// WithSync makes OpenPort send the autobaud byte with Sync after opening
+// the port. It is needed for controllers in legacy packetized serial mode.
+func WithSync(sync bool) Option {
+	return func(st *Sabertooth) {
+		st.syncOnOpen = sync
+	}
+}
+
+// WithPreTransmit sets a function called right before each write to the
+// serial port, for example to assert the driver enable pin of an RS-485
+// transceiver. It is called while holding the lock of the Sabertooth, so
+// it must not call any of its methods.
+func WithPreTransmit(f func()) Option {
+	return func(st *Sabertooth) {
+		st.preTransmit = f
+	}
+}
+
+// WithPostTransmit sets a function called right after each write to the
+// serial port returns, for example to deassert the driver enable pin of an
+// RS-485 transceiver so that the reply can be received. The write may
+// return before the last byte has left the UART, so the function may need
+// to wait for the transmission to complete. It is called while holding the
+// lock of the Sabertooth, so it must not call any of its methods.
+func WithPostTransmit(f func()) Option {
+	return func(st *Sabertooth) {
+		st.postTransmit = f
+	}
+}
+
+// WithAllowMotorWhenDisabled lets Motor send commands while the outputs are
+// disabled with Disable, instead of returning ErrDisabled. The commands
+// take effect when the outputs are enabled again.
+func WithAllowMotorWhenDisabled(allow bool) Option {
+	return func(st *Sabertooth) {
+		st.allowWhenDisabled = allow
+	}
+}
+
+// WithSimplifiedSerial selects simplified serial mode, in which each
+// command is a single byte with no address or checksum. Only Motor and
+// Stop are available in this mode; the device can not be read and the
+// other Set commands return ErrSimplifiedSerial. The address is ignored.
+// Speeds are sent with a resolution of 1/63.
+func WithSimplifiedSerial(simplified bool) Option {
+	return func(st *Sabertooth) {
+		st.simplified = simplified
+	}
+}
+
+// WithAutoOpen makes commands sent after Close open the port again instead
+// of returning ErrPortClosed
+func WithAutoOpen(autoOpen bool) Option {
+	return func(st *Sabertooth) {
+		st.autoOpen = autoOpen
+	}
+}
+
+// WithValueDecoder replaces the decoding of the value of replies, for
+// firmware that encodes it differently. The default is DecodeValue.
+func WithValueDecoder(decoder ValueDecoder) Option {
+	return func(st *Sabertooth) {
+		st.valueDecoder = decoder
+	}
+}
+
+// WithVerifyWrites makes Motor read back the value of the motor after
+// setting it and return ErrVerifyMismatch if it differs by more than 1%
+// of full scale. This doubles the number of round trips per command. The
+// device reports the value with ramping applied, so verification fails
+// while the controller ramps toward a new speed. Off by default.
+func WithVerifyWrites(verify bool) Option {
+	return func(st *Sabertooth) {
+		st.verifyWrites = verify
+	}
+}
+
+// WithInterFrameDelay adds a delay after each write to the serial port,
+// before the reply is read and before the next command is sent. It gives
+// slow hardware, or an RS-485 transceiver turning around, time to settle.
+// The delay is added after the post transmit hook. The default is 0.
+func WithInterFrameDelay(d time.Duration) Option {
+	return func(st *Sabertooth) {
+		st.interFrameDelay = d
+	}
+}
+
+// WithRetries makes reads that time out, with a partial reply or none at
+// all, be retried up to retries times. Before each retry the input buffer
+// is flushed, so that late bytes of the failed reply can not be mistaken
+// for the next reply. Replies that fail a checksum are not retried; use
+// ReadChecked for those. The default is no retries.
+func WithRetries(retries int) Option {
+	return func(st *Sabertooth) {
+		st.retries = retries
+	}
+}
+
+// WithLogger sets the logger that traces the bytes sent to and received
+// from the device, see SetLogger. By default nothing is logged.
+func WithLogger(logger *log.Logger) Option {
+	return func(st *Sabertooth) {
+		st.logger = logger
+	}
+}
+
+// WithModel sets the model name returned by Model, for connections that
+// can not report it such as TTL serial, and the number of temperature
+// sensors of the model, 1 or 2. On a model with a single sensor Temp(2)
+// returns ErrUnsupported rather than a duplicate or bogus reading. By
+// default two sensors are assumed.
+func WithModel(model string, tempSensors int) Option {
+	return func(st *Sabertooth) {
+		st.model = model
+		st.tempSensors = tempSensors
+	}
+}
+
+// WithLatencyCompensation sets whether RunScript, StopRamped and
+// MotorSCurve shorten each wait by the round trip time to the device, so
+// that the time spent sending commands does not add up over a long script.
+// The round trip time is measured with RoundTripTime when the script or
+// ramp starts.
+func WithLatencyCompensation(enabled bool) Option {
+	return func(st *Sabertooth) {
+		st.compensateLatency = enabled
+	}
+}
+
+// WithVerifyBaudOnOpen sets whether OpenPort checks that the device replies
+// at the configured baud rate, by pinging it after opening the port. Some
+// drivers accept any baud rate without error, which otherwise only shows
+// as failing reads later. If the device gives no valid reply OpenPort
+// closes the port again and returns ErrBaudMismatch; DetectBaud finds the
+// baud rate the device uses. The check is not done in simplified serial
+// mode, which has no replies, and is repeated each time a transient port
+// is opened.
+func WithVerifyBaudOnOpen(enabled bool) Option {
+	return func(st *Sabertooth) {
+		st.verifyBaudOnOpen = enabled
+	}
+}
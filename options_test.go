@@ -0,0 +1,326 @@
+package sabertooth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// fakeOpen makes ports opened by name open fake ports. It returns the
+// ports opened so far and a function restoring the real open.
+func fakeOpen() (*[]*fakePort, func()) {
+	var opened []*fakePort
+	orig := openSerial
+	openSerial = func(name string, mode *serial.Mode) (serial.Port, error) {
+		port := &fakePort{mode: *mode}
+		opened = append(opened, port)
+		return port, nil
+	}
+	return &opened, func() {
+		openSerial = orig
+	}
+}
+
+func TestTransientPort(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(128, "fake", WithTransientPort(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		err = st.Motor(1, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*opened) != 2 {
+		t.Fatalf("port opened %d times, want 2", len(*opened))
+	}
+	for i, port := range *opened {
+		if !port.closed {
+			t.Errorf("port %d left open", i)
+		}
+		expectWrites(t, port, setCommand(128, CmdSetValue, 'M', 1, 1024))
+	}
+}
+
+func TestPersistentPort(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(128, "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		err = st.Motor(1, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*opened) != 1 {
+		t.Fatalf("port opened %d times, want 1", len(*opened))
+	}
+	port := (*opened)[0]
+	if port.closed {
+		t.Error("port closed between commands")
+	}
+	err = st.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !port.closed {
+		t.Error("port not closed by Close")
+	}
+}
+
+func TestCommandsAfterClose(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(128, "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.OpenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := map[string]func() error{
+		"Motor": func() error { return st.Motor(1, 0.5) },
+		"Read": func() error {
+			_, err := st.Read(CmdGetBattery, 'M', 1)
+			return err
+		},
+		"Battery": func() error {
+			_, err := st.Battery()
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrPortClosed) {
+			t.Errorf("%s after Close: got %v, want ErrPortClosed", name, err)
+		}
+	}
+	if len(*opened) != 1 {
+		t.Errorf("port opened %d times, want 1", len(*opened))
+	}
+}
+
+func TestAutoOpenAfterClose(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(128, "fake", WithAutoOpen(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetReadTimeout(20 * time.Millisecond)
+	err = st.OpenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Motor(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*opened) != 2 {
+		t.Fatalf("port opened %d times, want 2", len(*opened))
+	}
+	port := (*opened)[1]
+	port.queue(reply(128, CmdGetBattery, 120, 'M', 1))
+	bat, err := st.Battery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bat != 12 {
+		t.Errorf("battery %v, want 12", bat)
+	}
+}
+
+func TestInterFrameDelay(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	st, port := newFake(WithInterFrameDelay(delay))
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		err := st.Motor(1, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 3*delay {
+		t.Errorf("3 frames took %v, want at least %v", elapsed, 3*delay)
+	}
+	if n := len(port.written()); n != 3 {
+		t.Errorf("%d frames written, want 3", n)
+	}
+
+	st, _ = newFake()
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		err := st.Motor(1, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("3 frames without delay took %v", elapsed)
+	}
+}
+
+// fakeOpenDevice is fakeOpen with the opened ports answering Gets with
+// respond
+func fakeOpenDevice(respond func(cmd []byte) []byte) (*[]*fakePort, func()) {
+	opened, restore := fakeOpen()
+	open := openSerial
+	openSerial = func(name string, mode *serial.Mode) (serial.Port, error) {
+		port, err := open(name, mode)
+		port.(*fakePort).respond = respond
+		return port, err
+	}
+	return opened, restore
+}
+
+func TestVerifyBaudOnOpen(t *testing.T) {
+	garbled := reply(128, CmdGetBattery, 245, 'M', 1)
+	garbled[8] ^= 0x01
+	tests := []struct {
+		name     string
+		reply    []byte
+		mismatch bool
+	}{
+		{"valid reply", reply(128, CmdGetBattery, 245, 'M', 1), false},
+		{"garbled reply", garbled, true},
+		{"short garbage", []byte{0xf8, 0x00, 0xfe}, true},
+	}
+	for _, test := range tests {
+		opened, restore := fakeOpenDevice(func(cmd []byte) []byte {
+			return test.reply
+		})
+		st, err := NewSabertooth(128, "fake", WithVerifyBaudOnOpen(true), WithTransientPort(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		st.SetReadTimeout(20 * time.Millisecond)
+		err = st.OpenPort()
+		if test.mismatch != errors.Is(err, ErrBaudMismatch) {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+		if !test.mismatch && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		port := (*opened)[0]
+		expectWrites(t, port, getCommand(128, CmdGetBattery, 'M', 1))
+		// Closed after a failed check, kept open otherwise even if transient
+		if port.closed != test.mismatch {
+			t.Errorf("%s: port closed is %v", test.name, port.closed)
+		}
+		restore()
+	}
+}
+
+func TestVerifyBaudOnOpenNoReply(t *testing.T) {
+	_, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(128, "fake", WithVerifyBaudOnOpen(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetReadTimeout(20 * time.Millisecond)
+	err = st.OpenPort()
+	// A device at another baud rate may not answer at all
+	if !errors.Is(err, ErrBaudMismatch) {
+		t.Errorf("got %v, want ErrBaudMismatch", err)
+	}
+}
+
+func TestVerifyBaudOnOpenSimplified(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(128, "fake", WithVerifyBaudOnOpen(true), WithSimplifiedSerial(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.OpenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, (*opened)[0])
+}
+
+func TestVerifyBaudOnOpenDetectBaud(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(128, "fake", WithVerifyBaudOnOpen(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetReadTimeout(20 * time.Millisecond)
+	if err := st.OpenPort(); !errors.Is(err, ErrBaudMismatch) {
+		t.Fatalf("got %v, want ErrBaudMismatch", err)
+	}
+	// DetectBaud opens the port again itself
+	openSerial = func(name string, mode *serial.Mode) (serial.Port, error) {
+		port := &fakePort{mode: *mode}
+		port.respond = func(cmd []byte) []byte {
+			// Called by Write holding port.mu
+			if port.mode.BaudRate != 9600 {
+				return []byte{0xf8, 0x00, 0xfe}
+			}
+			return telemetryDevice(cmd)
+		}
+		*opened = append(*opened, port)
+		return port, nil
+	}
+	baud, err := st.DetectBaud()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baud != 9600 {
+		t.Errorf("detected %d baud, want 9600", baud)
+	}
+}
+
+func TestDiscoverClosesPortOnBaudMismatch(t *testing.T) {
+	opened, restore := fakeOpenDevice(func(cmd []byte) []byte {
+		return []byte{0xf8, 0x00, 0xfe}
+	})
+	defer restore()
+	defer fakePorts(sabertoothPort)()
+	_, err := Discover(128, WithVerifyBaudOnOpen(true))
+	if !errors.Is(err, ErrBaudMismatch) {
+		t.Fatalf("got %v, want ErrBaudMismatch", err)
+	}
+	if !(*opened)[0].closed {
+		t.Error("port left open")
+	}
+}
+
+func TestSyncOnOpenFails(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	open := openSerial
+	openSerial = func(name string, mode *serial.Mode) (serial.Port, error) {
+		port, err := open(name, mode)
+		port.(*fakePort).writeErr = errors.New("input/output error")
+		return port, err
+	}
+	st, err := NewSabertooth(128, "fake", WithSync(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.OpenPort(); err == nil {
+		t.Fatal("OpenPort succeeded with a failing sync")
+	}
+	if !(*opened)[0].closed {
+		t.Error("port left open")
+	}
+}
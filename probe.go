@@ -0,0 +1,214 @@
+package sabertooth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// probeTimeout is the read timeout used when probing the device
+const probeTimeout = 100 * time.Millisecond
+
+// Ping checks that the device replies, by reading the battery voltage
+func (st *Sabertooth) Ping() error {
+	_, err := st.Read(CmdGetBattery, 'M', 1)
+	return err
+}
+
+// SelfTest checks the communication with the device without moving the
+// motors. It reads the battery voltage, without retries or resync, and
+// checks that the reply is well formed: its checksums, that it comes from
+// the device address and that it answers the request sent. Unlike Ping,
+// which only checks that a reply can be read, a reply that Read would
+// recover from is reported as an error here. The error is a
+// *ProtocolError holding the frame.
+func (st *Sabertooth) SelfTest() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.simplified {
+		return ErrSimplifiedSerial
+	}
+	if st.port != nil {
+		st.port.ResetInputBuffer()
+	}
+	data, err := st.transaction(getCommand(st.address, CmdGetBattery, 'M', 1), replyLength(CmdGetBattery))
+	if err != nil {
+		return err
+	}
+	packet, err := decodePacket(data, st.valueDecoder)
+	if err != nil {
+		return err
+	}
+	switch {
+	case packet.Address != st.address:
+		err = fmt.Errorf("reply from address %d, expected %d", packet.Address, st.address)
+	case packet.Target != CmdGetBattery:
+		err = fmt.Errorf("reply to %s, expected battery", getTypeName(packet.Target))
+	case packet.Type != 'M' || packet.Number != 1:
+		err = fmt.Errorf("reply for %c%d, expected M1", packet.Type, packet.Number)
+	}
+	if err != nil {
+		return &ProtocolError{Op: "self test", Frame: data, Err: err}
+	}
+	return nil
+}
+
+// Capabilities reports which values a device can read
+type Capabilities struct {
+	Battery  bool
+	Current1 bool
+	Current2 bool
+	Temp1    bool
+	Temp2    bool
+	S1       bool
+	S2       bool
+	A1       bool
+	A2       bool
+}
+
+// Capabilities probes which values the device can read by reading each of
+// them with a short timeout. A value is supported if the device gives a
+// valid reply. An error is returned if the device does not reply at all or
+// the port fails.
+func (st *Sabertooth) Capabilities() (Capabilities, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	readTimeout := st.readTimeout
+	st.readTimeout = probeTimeout
+	defer func() {
+		st.readTimeout = readTimeout
+	}()
+
+	var c Capabilities
+	_, err := st.read(CmdGetBattery, 'M', 1)
+	if err != nil {
+		return c, err
+	}
+	c.Battery = true
+	probes := []struct {
+		supported             *bool
+		param, target, number byte
+	}{
+		{&c.Current1, CmdGetCurrent, 'M', 1},
+		{&c.Current2, CmdGetCurrent, 'M', 2},
+		{&c.Temp1, CmdGetTemp, 'M', 1},
+		{&c.Temp2, CmdGetTemp, 'M', 2},
+		{&c.S1, CmdGetValue, 'S', 1},
+		{&c.S2, CmdGetValue, 'S', 2},
+		{&c.A1, CmdGetValue, 'A', 1},
+		{&c.A2, CmdGetValue, 'A', 2},
+	}
+	for _, p := range probes {
+		// Drop any late reply to a previous probe
+		if st.port != nil {
+			st.port.ResetInputBuffer()
+		}
+		_, err = st.read(p.param, p.target, p.number)
+		var protocolErr *ProtocolError
+		if err != nil && !errors.As(err, &protocolErr) {
+			return c, err
+		}
+		*p.supported = err == nil
+	}
+	return c, nil
+}
+
+// baudRates are the baud rates supported by the controller, in the order
+// DetectBaud tries them
+var baudRates = []int{115200, 38400, 19200, 9600, 2400}
+
+// DetectBaud finds the baud rate of the controller by trying each
+// supported baud rate until the device gives a valid reply. The port is
+// left at the detected baud rate. If no baud rate works the port is set
+// back to the baud rate it had.
+func (st *Sabertooth) DetectBaud() (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	readTimeout, baudRate, verifyBaud := st.readTimeout, st.baudRate, st.verifyBaudOnOpen
+	st.readTimeout = probeTimeout
+	st.verifyBaudOnOpen = false
+	defer func() {
+		st.readTimeout = readTimeout
+		st.verifyBaudOnOpen = verifyBaud
+	}()
+
+	for _, baud := range baudRates {
+		err := st.setBaudRate(baud)
+		if err != nil {
+			return 0, err
+		}
+		_, err = st.read(CmdGetBattery, 'M', 1)
+		if err == nil {
+			return baud, nil
+		}
+		var protocolErr *ProtocolError
+		if !errors.As(err, &protocolErr) {
+			st.setBaudRate(baudRate)
+			return 0, err
+		}
+	}
+	err := st.setBaudRate(baudRate)
+	if err != nil {
+		return 0, err
+	}
+	return 0, errors.New("no baud rate detected")
+}
+
+// AddressScan is the result of scanning an address with ScanAddresses
+type AddressScan struct {
+	Address byte
+	// Replies is the number of valid replies
+	Replies int
+	// Invalid is the number of replies that failed to decode
+	Invalid int
+	// Collision is set when the replies suggest that more than one device
+	// uses the address: some replies were garbled or the battery voltages
+	// in the valid replies disagree
+	Collision bool
+}
+
+// collisionTolerance is the largest difference, in tenths of a volt,
+// between battery readings of one device that ScanAddresses accepts
+const collisionTolerance = 5
+
+// ScanAddresses pings each packet serial address, 128 to 135, pings times
+// on an open serial port and returns the addresses that replied. Two
+// devices at the same address reply at the same time and garble each
+// other's replies, so addresses with garbled or inconsistent replies are
+// flagged as collisions.
+func ScanAddresses(port serial.Port, pings int) ([]AddressScan, error) {
+	var scans []AddressScan
+	for address := 128; address <= 135; address++ {
+		st := NewSabertoothWithPort(byte(address), port)
+		st.readTimeout = probeTimeout
+		scan := AddressScan{Address: byte(address)}
+		var first int
+		for i := 0; i < pings; i++ {
+			port.ResetInputBuffer()
+			value, err := st.Read(CmdGetBattery, 'M', 1)
+			var protocolErr *ProtocolError
+			var timeoutErr *TimeoutError
+			switch {
+			case err == nil:
+				if scan.Replies == 0 {
+					first = value
+				} else if value-first > collisionTolerance || first-value > collisionTolerance {
+					scan.Collision = true
+				}
+				scan.Replies++
+			case errors.As(err, &timeoutErr) && len(timeoutErr.Received) == 0:
+			case errors.As(err, &protocolErr):
+				scan.Invalid++
+				scan.Collision = true
+			default:
+				return scans, err
+			}
+		}
+		if scan.Replies > 0 || scan.Invalid > 0 {
+			scans = append(scans, scan)
+		}
+	}
+	return scans, nil
+}
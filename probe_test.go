@@ -0,0 +1,155 @@
+package sabertooth
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanAddresses(t *testing.T) {
+	pings := map[byte]int{}
+	port := &fakePort{respond: func(cmd []byte) []byte {
+		address := cmd[0]
+		pings[address]++
+		switch address {
+		case 128:
+			return reply(address, CmdGetBattery, 245, 'M', 1)
+		case 130:
+			// Two devices garbling each other on every other ping
+			r := reply(address, CmdGetBattery, 245, 'M', 1)
+			if pings[address]%2 == 0 {
+				r[8] ^= 0x10
+			}
+			return r
+		case 131:
+			// Two devices at different voltages taking turns to reply
+			if pings[address]%2 == 0 {
+				return reply(address, CmdGetBattery, 120, 'M', 1)
+			}
+			return reply(address, CmdGetBattery, 245, 'M', 1)
+		}
+		return nil
+	}}
+	scans, err := ScanAddresses(port, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AddressScan{
+		{Address: 128, Replies: 2},
+		{Address: 130, Replies: 1, Invalid: 1, Collision: true},
+		{Address: 131, Replies: 2, Collision: true},
+	}
+	if !reflect.DeepEqual(scans, want) {
+		t.Errorf("got %+v, want %+v", scans, want)
+	}
+	if port.resets != 16 {
+		t.Errorf("input flushed %d times, want 16", port.resets)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	good := reply(128, CmdGetBattery, 245, 'M', 1)
+	badChecksum := append([]byte(nil), good...)
+	badChecksum[8] ^= 0x01
+	tests := []struct {
+		name  string
+		reply []byte
+		// err is part of the error message, or empty if none is checked
+		err string
+	}{
+		{"other address", reply(129, CmdGetBattery, 245, 'M', 1), "reply from address 129, expected 128"},
+		{"other value", reply(128, CmdGetCurrent, 12, 'M', 1), "reply to current, expected battery"},
+		{"other number", reply(128, CmdGetBattery, 245, 'M', 2), "reply for M2, expected M1"},
+		{"bad checksum", badChecksum, ErrDataChecksum.Error()},
+		{"shifted", append([]byte{0x55}, good[:8]...), ""},
+	}
+	st, port := newFake()
+	port.queue(good)
+	if err := st.SelfTest(); err != nil {
+		t.Errorf("valid reply: %v", err)
+	}
+	for _, test := range tests {
+		st, port := newFake()
+		port.queue(test.reply)
+		err := st.SelfTest()
+		var protocolErr *ProtocolError
+		if !errors.As(err, &protocolErr) {
+			t.Errorf("%s: got %v, want a *ProtocolError", test.name, err)
+			continue
+		}
+		if !bytes.Equal(protocolErr.Frame, test.reply) {
+			t.Errorf("%s: frame [% x], want [% x]", test.name, protocolErr.Frame, test.reply)
+		}
+		if test.err != "" && !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %q does not contain %q", test.name, err, test.err)
+		}
+		if port.resets != 1 {
+			t.Errorf("%s: input flushed %d times, want 1", test.name, port.resets)
+		}
+	}
+}
+
+func TestSelfTestSimplified(t *testing.T) {
+	st, port := newFake(WithSimplifiedSerial(true))
+	if err := st.SelfTest(); err != ErrSimplifiedSerial {
+		t.Errorf("got %v, want ErrSimplifiedSerial", err)
+	}
+	expectWrites(t, port)
+}
+
+// baudDevice is a fake port with a device replying only at baud, and
+// garbage at other baud rates
+func baudDevice(baud int) *fakePort {
+	port := &fakePort{}
+	port.respond = func(cmd []byte) []byte {
+		// Called by Write holding port.mu
+		if port.mode.BaudRate != baud {
+			return []byte{0xf8, 0x00}
+		}
+		return telemetryDevice(cmd)
+	}
+	return port
+}
+
+func TestDetectBaud(t *testing.T) {
+	port := baudDevice(19200)
+	st := NewSabertoothWithPort(128, port, WithBaudRate(9600))
+	baud, err := st.DetectBaud()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baud != 19200 {
+		t.Errorf("detected %d baud, want 19200", baud)
+	}
+	if port.mode.BaudRate != 19200 {
+		t.Errorf("port left at %d baud, want 19200", port.mode.BaudRate)
+	}
+	// 115200 and 38400 are tried first
+	if n := len(port.written()); n != 3 {
+		t.Errorf("%d pings, want 3", n)
+	}
+	bat, err := st.Battery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bat != 24.5 {
+		t.Errorf("battery %v at the detected baud rate, want 24.5", bat)
+	}
+}
+
+func TestDetectBaudNoReply(t *testing.T) {
+	port := baudDevice(57600)
+	st := NewSabertoothWithPort(128, port, WithBaudRate(9600))
+	_, err := st.DetectBaud()
+	if err == nil {
+		t.Fatal("baud rate detected without a valid reply")
+	}
+	if port.mode.BaudRate != 9600 {
+		t.Errorf("port left at %d baud, want the original 9600", port.mode.BaudRate)
+	}
+	if n := len(port.written()); n != len(baudRates) {
+		t.Errorf("%d pings, want %d", n, len(baudRates))
+	}
+}
@@ -0,0 +1,123 @@
+package sabertooth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// waitForPortInterval is the time between the port scans of
+// WaitForSerialPort
+const waitForPortInterval = 100 * time.Millisecond
+
+// WaitForSerialPort waits until a serial port named portName exists, for
+// example after a USB adapter has been plugged in. It returns ctx.Err() if
+// ctx is done first.
+func WaitForSerialPort(ctx context.Context, portName string) error {
+	for {
+		ports, err := portsList()
+		if err != nil {
+			return err
+		}
+		for _, p := range ports {
+			if p == portName {
+				return nil
+			}
+		}
+		err = sleep(ctx, waitForPortInterval)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReconnectingSabertooth is a Sabertooth that reconnects when the serial
+// port fails, for example on a flaky USB connection. On a *TransportError
+// it closes the port, waits up to Timeout for the port to reappear, opens
+// it again and retries the call once. The settings of the device, such as
+// the baud rate, read timeout and motor inversion, are kept.
+//
+// Only the methods of ReconnectingSabertooth retry; the methods of the
+// embedded Sabertooth do not. Use Do for other calls.
+type ReconnectingSabertooth struct {
+	*Sabertooth
+	Timeout time.Duration
+}
+
+// NewReconnectingSabertooth wraps st so that it reconnects on transport
+// errors, waiting up to timeout for the port to reappear
+func NewReconnectingSabertooth(st *Sabertooth, timeout time.Duration) *ReconnectingSabertooth {
+	return &ReconnectingSabertooth{st, timeout}
+}
+
+// Do calls f with the device, reconnecting and calling f again once if it
+// fails with a *TransportError
+func (r *ReconnectingSabertooth) Do(f func(st *Sabertooth) error) error {
+	err := f(r.Sabertooth)
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) {
+		return err
+	}
+	reconnectErr := r.reconnect()
+	if reconnectErr != nil {
+		r.mu.Lock()
+		r.logf("reconnect: %v", reconnectErr)
+		r.mu.Unlock()
+		return err
+	}
+	return f(r.Sabertooth)
+}
+
+// reconnect closes the port, waits for it to reappear and opens it again
+func (r *ReconnectingSabertooth) reconnect() error {
+	st := r.Sabertooth
+	st.mu.Lock()
+	if st.externalPort {
+		st.mu.Unlock()
+		return errors.New("can not reconnect an external port")
+	}
+	st.closePort()
+	portName := st.portName
+	st.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+	defer cancel()
+	err := WaitForSerialPort(ctx, portName)
+	if err != nil {
+		return err
+	}
+	return st.OpenPortContext(ctx)
+}
+
+// Motor is Sabertooth.Motor with reconnect
+func (r *ReconnectingSabertooth) Motor(motor int, speed float64) error {
+	return r.Do(func(st *Sabertooth) error {
+		return st.Motor(motor, speed)
+	})
+}
+
+// Stop is Sabertooth.Stop with reconnect
+func (r *ReconnectingSabertooth) Stop() error {
+	return r.Do(func(st *Sabertooth) error {
+		return st.Stop()
+	})
+}
+
+// Read is Sabertooth.Read with reconnect
+func (r *ReconnectingSabertooth) Read(param, target, number byte) (int, error) {
+	var value int
+	err := r.Do(func(st *Sabertooth) (err error) {
+		value, err = st.Read(param, target, number)
+		return err
+	})
+	return value, err
+}
+
+// Battery is Sabertooth.Battery with reconnect
+func (r *ReconnectingSabertooth) Battery() (float64, error) {
+	var bat float64
+	err := r.Do(func(st *Sabertooth) (err error) {
+		bat, err = st.Battery()
+		return err
+	})
+	return bat, err
+}
@@ -0,0 +1,124 @@
+package sabertooth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForSerialPort(t *testing.T) {
+	defer fakePorts()()
+	scans := 0
+	portsList = func() ([]string, error) {
+		scans++
+		if scans < 3 {
+			return []string{"/dev/ttyUSB0"}, nil
+		}
+		return []string{"/dev/ttyUSB0", "/dev/ttyACM0"}, nil
+	}
+	err := WaitForSerialPort(context.Background(), "/dev/ttyACM0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scans != 3 {
+		t.Errorf("%d scans, want 3", scans)
+	}
+}
+
+func TestWaitForSerialPortTimeout(t *testing.T) {
+	defer fakePorts(otherPort)()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := WaitForSerialPort(ctx, "/dev/ttyACM0")
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// disconnected makes the writes to port fail as on an unplugged adapter
+func disconnected(port *fakePort) {
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	port.writeErr = errors.New("input/output error")
+}
+
+func TestReconnect(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	defer fakePorts(sabertoothPort)()
+	st, err := NewSabertooth(128, sabertoothPort.Name, WithBaudRate(38400))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetInverted(1, true)
+	r := NewReconnectingSabertooth(st, time.Second)
+	err = r.Motor(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disconnected((*opened)[0])
+	err = r.Motor(1, 0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*opened) != 2 {
+		t.Fatalf("port opened %d times, want 2", len(*opened))
+	}
+	if !(*opened)[0].closed {
+		t.Error("failed port left open")
+	}
+	port := (*opened)[1]
+	// The inversion and the baud rate are kept
+	expectWrites(t, port, setCommand(128, CmdSetValue, 'M', 1, -512))
+	if port.mode.BaudRate != 38400 {
+		t.Errorf("reopened at %d baud, want 38400", port.mode.BaudRate)
+	}
+}
+
+func TestReconnectPortGone(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	defer fakePorts(sabertoothPort)()
+	st, err := NewSabertooth(128, sabertoothPort.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReconnectingSabertooth(st, 50*time.Millisecond)
+	err = r.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	disconnected((*opened)[0])
+	portsList = func() ([]string, error) {
+		return nil, nil
+	}
+	err = r.Stop()
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) || transportErr.Op != "write" {
+		t.Errorf("got %v, want the write TransportError", err)
+	}
+	if len(*opened) != 1 {
+		t.Errorf("port opened %d times, want 1", len(*opened))
+	}
+}
+
+func TestReconnectOnlyOnTransportErrors(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(128, sabertoothPort.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetReadTimeout(20 * time.Millisecond)
+	r := NewReconnectingSabertooth(st, time.Second)
+	_, err = r.Battery()
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a TimeoutError", err)
+	}
+	if len(*opened) != 1 {
+		t.Errorf("port opened %d times, want 1", len(*opened))
+	}
+	expectWrites(t, (*opened)[0], getCommand(128, CmdGetBattery, 'M', 1))
+}
@@ -0,0 +1,67 @@
+package sabertooth
+
+import (
+	"sync"
+
+	"go.bug.st/serial"
+)
+
+// sharedPort is a serial port opened by Get, shared by the devices at the
+// different addresses on it
+type sharedPort struct {
+	port    serial.Port
+	mu      *sync.Mutex
+	devices map[byte]*Sabertooth
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*sharedPort{}
+)
+
+// Get returns a shared Sabertooth for the device with the given address on
+// the given serial port. The port is opened on the first call for it, and
+// the devices at all addresses on the port use that single open port and
+// a single lock, so that their commands never interleave. Later calls with
+// the same port and address return the same instance. Use CloseAll to
+// close the shared ports.
+func Get(portName string, address byte) (*Sabertooth, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	sp, ok := registry[portName]
+	if !ok {
+		port, err := openSerial(portName, &serial.Mode{BaudRate: DefaultBaudRate})
+		if err != nil {
+			return nil, openError(portName, err)
+		}
+		sp = &sharedPort{port, new(sync.Mutex), map[byte]*Sabertooth{}}
+		registry[portName] = sp
+	}
+	if st, ok := sp.devices[address]; ok {
+		return st, nil
+	}
+	st := NewSabertoothWithPort(address, sp.port)
+	st.mu = sp.mu
+	st.portName = portName
+	sp.devices[address] = st
+	return st, nil
+}
+
+// CloseAll closes the ports opened by Get, each once, and clears the
+// registry. The first error encountered is returned, but all ports are
+// closed regardless.
+func CloseAll() error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	var firstErr error
+	for portName, sp := range registry {
+		sp.mu.Lock()
+		err := sp.port.Close()
+		sp.mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(registry, portName)
+	}
+	return firstErr
+}
@@ -0,0 +1,108 @@
+package sabertooth
+
+import (
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// closeCounter is a fakePort counting how often it is closed
+type closeCounter struct {
+	*fakePort
+	closes int
+}
+
+func (p *closeCounter) Close() error {
+	p.closes++
+	return p.fakePort.Close()
+}
+
+func TestGetSharesPort(t *testing.T) {
+	var opened []*closeCounter
+	orig := openSerial
+	defer func() {
+		openSerial = orig
+	}()
+	openSerial = func(name string, mode *serial.Mode) (serial.Port, error) {
+		port := &closeCounter{fakePort: &fakePort{mode: *mode}}
+		opened = append(opened, port)
+		return port, nil
+	}
+	defer CloseAll()
+	left, err := Get("/dev/ttyUSB0", 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	right, err := Get("/dev/ttyUSB0", 129)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Get("/dev/ttyUSB0", 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != left {
+		t.Error("Get returned a new instance for the same port and address")
+	}
+	if len(opened) != 1 {
+		t.Fatalf("port opened %d times, want 1", len(opened))
+	}
+	if got, want := right.String(), "sabertooth 129 on /dev/ttyUSB0"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	// A command to one address waits for the reply awaited by the other
+	left.SetReadTimeout(100 * time.Millisecond)
+	start := time.Now()
+	go left.Battery()
+	time.Sleep(20 * time.Millisecond)
+	err = right.Motor(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("command written after %v while a reply was awaited", elapsed)
+	}
+	expectWrites(t, opened[0].fakePort,
+		getCommand(128, CmdGetBattery, 'M', 1),
+		setCommand(129, CmdSetValue, 'M', 1, 1024),
+	)
+
+	// The shared devices can form a Bus
+	bus, err := NewBus(left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bus.SyncMotors(map[byte]MotorCommand{128: {1, 0}, 129: {1, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CloseAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened[0].closes != 1 {
+		t.Errorf("port closed %d times, want 1", opened[0].closes)
+	}
+	// Get opens the port again after CloseAll
+	_, err = Get("/dev/ttyUSB0", 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opened) != 2 {
+		t.Errorf("port opened %d times, want 2", len(opened))
+	}
+}
+
+func TestGetOpenFails(t *testing.T) {
+	defer failOpen(&serial.PortError{})()
+	_, err := Get("/dev/ttyUSB1", 128)
+	if err == nil {
+		t.Fatal("Get succeeded with a busy port")
+	}
+	if len(registry) != 0 {
+		t.Errorf("failed port kept in the registry")
+	}
+}
@@ -1,7 +1,17 @@
 package sabertooth
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 
 	"go.bug.st/serial"
 	"go.bug.st/serial/enumerator"
@@ -22,13 +32,84 @@ const (
 	CmdGetTemp    = 64
 
 	CmdReply = 73
+
+	// Legacy packetized serial commands
+	CmdBaudRate = 15
+	CmdDeadband = 17
 )
 
-// Sabertooth represents a Sabertooth controllers
+// DefaultBaudRate is the default baud rate of the serial port
+const DefaultBaudRate = 115200
+
+// baudRateCodes maps the baud rates supported by the controller to the
+// value of the baud rate command
+var baudRateCodes = map[int]byte{
+	2400:   1,
+	9600:   2,
+	19200:  3,
+	38400:  4,
+	115200: 5,
+}
+
+// Target identifies a type of input or output of the device. Read and
+// Transaction take the raw byte instead.
+type Target byte
+
+// Targets
+const (
+	Motor  Target = 'M'
+	Power  Target = 'P'
+	Signal Target = 'S'
+	Aux    Target = 'A'
+)
+
+// maxValue is the magnitude of the value representing full scale
+const maxValue = 2047
+
+// DefaultReadTimeout is the default time to wait for a complete reply
+const DefaultReadTimeout = 500 * time.Millisecond
+
+// Sabertooth represents a Sabertooth controllers. It is safe for
+// concurrent use.
 type Sabertooth struct {
-	address  byte
-	portName string
-	port     serial.Port
+	// mu guards the device and its port. Devices sharing a port share mu.
+	mu                *sync.Mutex
+	address           byte
+	portName          string
+	port              serial.Port
+	transient         bool
+	externalPort      bool
+	baudRate          int
+	readTimeout       time.Duration
+	softStart         float64
+	channels          map[int]*channel
+	model             string
+	tempSensors       int
+	scratch           []byte
+	lastCommand       time.Time
+	syncOnOpen        bool
+	preTransmit       func()
+	postTransmit      func()
+	disabled          bool
+	allowWhenDisabled bool
+	simplified        bool
+	closed            bool
+	autoOpen          bool
+	valueDecoder      ValueDecoder
+	verifyWrites      bool
+	lastTX            []byte
+	lastRX            []byte
+	interFrameDelay   time.Duration
+	retries           int
+	logger            *log.Logger
+	name              string
+	ranges            map[Target]valueRange
+	watchdogPaused    bool
+	capture           io.Writer
+	extremes          TelemetryExtremes
+	compensateLatency bool
+	heartbeatLost     bool
+	verifyBaudOnOpen  bool
 }
 
 // Packet is a the data sent or received from a Sabertooth
@@ -40,40 +121,413 @@ type Packet struct {
 	Value   int16
 }
 
+// MarshalJSON encodes the packet with the Get type and the source type as
+// readable strings rather than raw byte values. For a reply packet Target
+// holds the Get type (CmdGetValue, CmdGetBattery, etc.) and Type holds the
+// source type character ('M', 'P', 'S' or 'A').
+func (p Packet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Address  byte   `json:"address"`
+		Target   string `json:"target"`
+		Type     string `json:"type"`
+		TypeName string `json:"typeName"`
+		Number   byte   `json:"number"`
+		Value    int16  `json:"value"`
+	}{
+		Address:  p.Address,
+		Target:   getTypeName(p.Target),
+		Type:     string(rune(p.Type)),
+		TypeName: sourceTypeName(p.Type),
+		Number:   p.Number,
+		Value:    p.Value,
+	})
+}
+
+// Normalize converts a raw value as used by the device, between -2047 and
+// 2047, to a value between -1 and 1. Raw values outside the range are
+// clamped. -2047 and 2047 convert to exactly -1 and 1, and Denormalize
+// converts -1 and 1 back to exactly -2047 and 2047.
+func Normalize(raw int) float64 {
+	if raw > maxValue {
+		raw = maxValue
+	} else if raw < -maxValue {
+		raw = -maxValue
+	}
+	return float64(raw) / maxValue
+}
+
+// Denormalize converts a value between -1 and 1 to a raw value as used by
+// the device, between -2047 and 2047. Values outside the range are clamped.
+// The result is rounded to the nearest raw value, with halves rounded away
+// from zero, so v and -v always give raw values of the same magnitude.
+func Denormalize(v float64) int16 {
+	if v > 1 {
+		v = 1
+	} else if v < -1 {
+		v = -1
+	}
+	return int16(math.Round(v * maxValue))
+}
+
 // NewSabertooth creates a new Sabertooth device. The default address is 128.
 // The portName is the serial port where the device is attached. You
 // se the SerialPort() function to find the USB serial port that the device
-// is connected to.
-func NewSabertooth(address byte, portName string) (*Sabertooth, error) {
-	st := Sabertooth{}
-	st.address = address
+// is connected to. The behaviour of the device can be adjusted with
+// options.
+func NewSabertooth(address byte, portName string, opts ...Option) (*Sabertooth, error) {
+	st := newSabertooth(address, opts)
 	st.portName = portName
 
-	return &st, nil
+	return st, nil
+}
+
+// NewSabertoothWithPort creates a new Sabertooth device using an already
+// opened serial port. The port is owned by the caller: OpenPort and Close
+// do not open or close it, and it is used for all commands until the
+// caller closes it.
+func NewSabertoothWithPort(address byte, port serial.Port, opts ...Option) *Sabertooth {
+	st := newSabertooth(address, opts)
+	st.port = port
+	st.externalPort = true
+	return st
+}
+
+// Discover finds the USB serial port of a Sabertooth with SerialPort,
+// opens it and checks that the device at address replies with Ping. The
+// returned error tells which of the steps failed.
+func Discover(address byte, opts ...Option) (*Sabertooth, error) {
+	portName, err := SerialPort()
+	if err != nil {
+		return nil, fmt.Errorf("find port: %w", err)
+	}
+	st, err := NewSabertooth(address, portName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = st.OpenPort()
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", portName, err)
+	}
+	err = st.Ping()
+	if err != nil {
+		st.Close()
+		return nil, fmt.Errorf("ping address %d on %s: %w", address, portName, err)
+	}
+	return st, nil
+}
+
+func newSabertooth(address byte, opts []Option) *Sabertooth {
+	st := Sabertooth{}
+	st.mu = new(sync.Mutex)
+	st.address = address
+	st.baudRate = DefaultBaudRate
+	st.readTimeout = DefaultReadTimeout
+	st.valueDecoder = DecodeValue
+	for _, opt := range opts {
+		opt(&st)
+	}
+	return &st
 }
 
-// OpenPort opens the servial port
+// OpenPort opens the servial port. If the port is held by another program
+// or the user lacks permission to open it, the error wraps ErrPortBusy or
+// ErrPermissionDenied. If the port opens but sending the autobaud byte of
+// WithSync or the check of WithVerifyBaudOnOpen fails, the port is closed
+// again.
 func (st *Sabertooth) OpenPort() error {
-	mode := &serial.Mode{
-		BaudRate: 115200,
+	return st.OpenPortContext(context.Background())
+}
+
+// OpenPortContext opens the serial port like OpenPort, but gives up when
+// ctx is done, as opening a port can block on some platforms. A port that
+// opens after ctx is done is closed again.
+func (st *Sabertooth) OpenPortContext(ctx context.Context) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := st.openPortContext(ctx)
+	if err != nil {
+		return err
+	}
+	st.closed = false
+	return nil
+}
+
+// openSerial opens a serial port by name. Tests replace it to open fake
+// ports.
+var openSerial = serial.Open
+
+// portsList and detailedPortsList list the serial ports. Tests replace
+// them to list fake ports.
+var (
+	portsList         = serial.GetPortsList
+	detailedPortsList = enumerator.GetDetailedPortsList
+)
+
+func (st *Sabertooth) openPort() error {
+	return st.openPortContext(context.Background())
+}
+
+func (st *Sabertooth) openPortContext(ctx context.Context) error {
+	if st.externalPort {
+		return nil
+	}
+	type result struct {
+		port serial.Port
+		err  error
+	}
+	done := make(chan result, 1)
+	portName, mode := st.portName, st.mode()
+	go func() {
+		port, err := openSerial(portName, mode)
+		done <- result{port, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return openError(portName, r.err)
+		}
+		st.port = r.port
+	case <-ctx.Done():
+		go func() {
+			r := <-done
+			if r.err == nil {
+				r.port.Close()
+			}
+		}()
+		return ctx.Err()
 	}
 	var err error
-	st.port, err = serial.Open(st.portName, mode)
+	if st.syncOnOpen {
+		err = st.sync()
+	}
+	if err == nil && st.verifyBaudOnOpen && !st.simplified {
+		err = st.verifyBaud()
+	}
 	if err != nil {
+		// Do not leave the port locked by a failed open
+		st.closePort()
 		return err
 	}
 	return nil
 }
 
+// verifyBaud pings the device after opening the port and returns
+// ErrBaudMismatch if it does not give a valid reply. The caller must hold
+// st.mu.
+func (st *Sabertooth) verifyBaud() error {
+	transient := st.transient
+	st.transient = false
+	defer func() {
+		st.transient = transient
+	}()
+	_, err := st.read(CmdGetBattery, 'M', 1)
+	var protocolErr *ProtocolError
+	if errors.As(err, &protocolErr) {
+		return fmt.Errorf("%w at %d baud, try DetectBaud: %v", ErrBaudMismatch, st.baudRate, err)
+	}
+	return err
+}
+
+func (st *Sabertooth) mode() *serial.Mode {
+	return &serial.Mode{
+		BaudRate: st.baudRate,
+	}
+}
+
+// Sync sends the autobaud byte 0xAA, which the controller uses to detect
+// the baud rate of the host. Controllers in legacy packetized serial mode,
+// such as the Sabertooth 2x25 and 2x12, require it before the first command
+// after power up. The USB capable controllers in packet serial mode detect
+// the baud rate without it and ignore the byte.
+func (st *Sabertooth) Sync() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := st.acquirePort()
+	if err != nil {
+		return err
+	}
+	defer st.releasePort()
+	return st.sync()
+}
+
+func (st *Sabertooth) sync() error {
+	return st.write([]byte{0xaa})
+}
+
+// PortMode returns the mode of the open serial port. The serial library
+// can not read the mode back from the operating system, so this is the
+// mode applied when the port was opened or last changed, which the driver
+// accepted without error. An error is returned if the port is not open.
+func (st *Sabertooth) PortMode() (serial.Mode, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.port == nil {
+		return serial.Mode{}, errors.New("port not open")
+	}
+	return *st.mode(), nil
+}
+
+// SetReadTimeout sets the time to wait for a complete reply from the device
+func (st *Sabertooth) SetReadTimeout(timeout time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.readTimeout = timeout
+}
+
+// SetLogger sets the logger that traces the bytes sent to and received
+// from the device. It can be called at any time, for example to capture a
+// trace on demand. nil disables logging.
+func (st *Sabertooth) SetLogger(logger *log.Logger) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.logger = logger
+}
+
+// logf logs to the logger of the device, if any. The caller must hold
+// st.mu.
+func (st *Sabertooth) logf(format string, v ...interface{}) {
+	if st.logger != nil {
+		st.logger.Printf("%s: %s", st.string(), fmt.Sprintf(format, v...))
+	}
+}
+
+// SetName sets a name for the device, for example "left_drive", used in
+// log output and by String. It is a host side label only.
+func (st *Sabertooth) SetName(name string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.name = name
+}
+
+// Name returns the name set with SetName
+func (st *Sabertooth) Name() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.name
+}
+
+// String describes the device by its name, if set, address and port
+func (st *Sabertooth) String() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.string()
+}
+
+func (st *Sabertooth) string() string {
+	s := fmt.Sprintf("sabertooth %d", st.address)
+	if st.portName != "" {
+		s += " on " + st.portName
+	}
+	if st.name != "" {
+		s = st.name + " (" + s + ")"
+	}
+	return s
+}
+
+// Close closes the serial port. After Close the commands return
+// ErrPortClosed until the port is opened again with OpenPort, unless
+// WithAutoOpen is used, in which case the port is opened again on the next
+// command.
+func (st *Sabertooth) Close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.externalPort {
+		st.closed = true
+	}
+	return st.closePort()
+}
+
+func (st *Sabertooth) closePort() error {
+	if st.port == nil || st.externalPort {
+		return nil
+	}
+	err := st.port.Close()
+	st.port = nil
+	return err
+}
+
+// acquirePort opens the serial port unless it is already open or has been
+// closed with Close
+func (st *Sabertooth) acquirePort() error {
+	if st.port != nil {
+		return nil
+	}
+	if st.closed && !st.autoOpen {
+		return ErrPortClosed
+	}
+	return st.openPort()
+}
+
+// releasePort closes the serial port after a command if the port is
+// transient
+func (st *Sabertooth) releasePort() {
+	if st.transient {
+		st.closePort()
+	}
+}
+
 // Input gets the input value of on any of the input ports of
-// the device. port can be 'S', 'A', 'M' or 'P'. n can be 1 or 2.
-// The returned value is between -1 and 1 inclusive.
-func (st *Sabertooth) Input(port byte, n int) (float64, error) {
-	value, err := st.Read(CmdGetValue, port, byte(n))
+// the device. port can be Signal, Aux, Motor or Power. n can be 1 or 2.
+// The returned value is between -1 and 1 inclusive. When the controller
+// is fed RC pulses, Signal returns the received RC values, see RCInput.
+func (st *Sabertooth) Input(port Target, n int) (float64, error) {
+	err := validateInput(port, n)
+	if err != nil {
+		return 0, err
+	}
+	value, err := st.Read(CmdGetValue, byte(port), byte(n))
+	if err != nil {
+		return 0, err
+	}
+	return Normalize(value), nil
+}
+
+// validateInput checks the port and number of an input. The device does
+// not reply at all to an invalid input, which would otherwise look like a
+// connection failure.
+func validateInput(port Target, n int) error {
+	switch port {
+	case Signal, Aux, Motor, Power:
+	default:
+		return fmt.Errorf("invalid input port %q", rune(port))
+	}
+	if n < 1 || n > 2 {
+		return fmt.Errorf("invalid input number %d", n)
+	}
+	return nil
+}
+
+// RCInput returns the value received on an RC input channel, 1 or 2,
+// between -1 and 1 inclusive. It reads the signal input (S1 or S2), which
+// carries the RC pulses when the controller is in RC mode.
+func (st *Sabertooth) RCInput(channel int) (float64, error) {
+	return st.Input(Signal, channel)
+}
+
+// InputPercent gets the input value of an input port like Input, but
+// returns it as a percentage between -100 and 100 inclusive.
+func (st *Sabertooth) InputPercent(port Target, n int) (float64, error) {
+	value, err := st.Input(port, n)
+	if err != nil {
+		return 0, err
+	}
+	return value * 100, nil
+}
+
+// InputVoltage gets the input value of an analog input port and scales it
+// to a voltage between 0 and refVoltage. The analog inputs are A1 and A2
+// (Aux), and S1 and S2 (Signal) when they are configured for analog input.
+// The device reports 0 V as -2047 and refVoltage as 2047.
+func (st *Sabertooth) InputVoltage(port Target, n int, refVoltage float64) (float64, error) {
+	err := validateInput(port, n)
+	if err != nil {
+		return 0, err
+	}
+	value, err := st.Read(CmdGetValue, byte(port), byte(n))
 	if err != nil {
 		return 0, err
 	}
-	return float64(value) / 2047, nil
+	return (Normalize(value) + 1) / 2 * refVoltage, nil
 }
 
 // Battery returns the battery voltage
@@ -85,6 +539,26 @@ func (st *Sabertooth) Battery() (float64, error) {
 	return float64(bat) / 10, nil
 }
 
+// BatteryPercent returns an estimate of the battery charge between 0 and
+// 100 percent, mapping the battery voltage linearly from minV (empty) to
+// maxV (full). Voltages outside the range are clamped.
+func (st *Sabertooth) BatteryPercent(minV, maxV float64) (float64, error) {
+	if maxV <= minV {
+		return 0, errors.New("invalid voltage range")
+	}
+	bat, err := st.Battery()
+	if err != nil {
+		return 0, err
+	}
+	percent := (bat - minV) / (maxV - minV) * 100
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	return percent, nil
+}
+
 // Current returns the electirical current in Ampere of a motor driver
 func (st *Sabertooth) Current(motor int) (float64, error) {
 	current, err := st.Read(CmdGetCurrent, 'M', byte(motor))
@@ -94,37 +568,319 @@ func (st *Sabertooth) Current(motor int) (float64, error) {
 	return float64(current) / 10, nil
 }
 
-// Temp returns the tempeture of a motor driver
+// Temp returns the tempeture of a motor driver in degrees Celsius. Unlike
+// the battery voltage and current, which are reported in tenths, the
+// device reports the temperature in whole degrees, so no precision is
+// lost by returning an int. On models with a single sensor, see WithModel,
+// Temp(2) returns ErrUnsupported.
 func (st *Sabertooth) Temp(motor int) (int, error) {
-	return st.Read(CmdGetTemp, 'M', byte(motor))
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.tempSensors == 1 && motor != 1 {
+		return 0, ErrUnsupported
+	}
+	return st.read(CmdGetTemp, 'M', byte(motor))
 }
 
 // Read reads of the parameters
 func (st *Sabertooth) Read(param, target, number byte) (int, error) {
-	if st.port == nil {
-		err := st.OpenPort()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.read(param, target, number)
+}
+
+// ReadWithTimeout reads a parameter like Read, but waits for the reply
+// for timeout instead of the read timeout of the device. Other commands
+// are not affected.
+func (st *Sabertooth) ReadWithTimeout(timeout time.Duration, param, target, number byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	readTimeout := st.readTimeout
+	st.readTimeout = timeout
+	defer func() {
+		st.readTimeout = readTimeout
+	}()
+	return st.read(param, target, number)
+}
+
+// ReadChecked reads a parameter like Read, but retries once if the reply
+// fails a checksum, which is often caused by a single burst of noise.
+func (st *Sabertooth) ReadChecked(param, target, number byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	value, err := st.read(param, target, number)
+	if errors.Is(err, ErrHeaderChecksum) || errors.Is(err, ErrDataChecksum) {
+		if st.port != nil {
+			st.port.ResetInputBuffer()
+		}
+		value, err = st.read(param, target, number)
+	}
+	return value, err
+}
+
+func (st *Sabertooth) read(param, target, number byte) (int, error) {
+	if st.simplified {
+		return 0, ErrSimplifiedSerial
+	}
+	value, err := st.readOnce(param, target, number)
+	for i := 0; i < st.retries && retriable(err); i++ {
+		// Drop the rest of a partial reply that may still arrive
+		if st.port != nil {
+			st.port.ResetInputBuffer()
+		}
+		value, err = st.readOnce(param, target, number)
+	}
+	return value, err
+}
+
+// retriable reports whether a failed read is retried with WithRetries.
+// Only timeouts are retried, whether a partial reply was received or none
+// at all. Replies failing to decode, such as checksum mismatches, are not
+// retried; ReadChecked retries those.
+func retriable(err error) bool {
+	var timeoutErr *TimeoutError
+	return errors.As(err, &timeoutErr)
+}
+
+func (st *Sabertooth) readOnce(param, target, number byte) (int, error) {
+	data, err := st.transaction(getCommand(st.address, param, target, number), replyLength(param))
+	if err != nil {
+		return 0, err
+	}
+	packet, err := decodePacket(data, st.valueDecoder)
+	if err != nil {
+		data = st.resync(data)
+		if data == nil {
+			return 0, err
+		}
+		packet, err = decodePacket(data, st.valueDecoder)
 		if err != nil {
 			return 0, err
 		}
 	}
-	_, err := st.port.Write(getCommand(st.address, param, target, number))
+	return int(packet.Value), nil
+}
+
+// resync recovers from a misaligned reply, for example after a dropped
+// byte. It searches data for the start of a reply, the device address
+// followed by CmdReply, discards the bytes before it and reads the rest of
+// the reply from the port. nil is returned if no reply start is found or
+// the rest can not be read. The caller must hold st.mu.
+func (st *Sabertooth) resync(data []byte) []byte {
+	if st.port == nil {
+		return nil
+	}
+	for i := 1; i < len(data); i++ {
+		if data[i] != st.address || (i+1 < len(data) && data[i+1] != CmdReply) {
+			continue
+		}
+		rest, err := st.readReply(i)
+		if err != nil {
+			return nil
+		}
+		return append(data[i:], rest...)
+	}
+	return nil
+}
+
+// RoundTripTime measures the time from writing a battery Get command to
+// receiving the complete reply. Opening the port is not included.
+func (st *Sabertooth) RoundTripTime() (time.Duration, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := st.acquirePort()
 	if err != nil {
 		return 0, err
 	}
-
-	data := make([]byte, 9)
-	n, err := st.port.Read(data)
+	start := time.Now()
+	_, err = st.read(CmdGetBattery, 'M', 1)
 	if err != nil {
 		return 0, err
 	}
-	if n != 9 {
-		return 0, errors.New("unexpected data length")
+	return time.Since(start), nil
+}
+
+// LastTX returns a copy of the bytes last written to the device
+func (st *Sabertooth) LastTX() []byte {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]byte(nil), st.lastTX...)
+}
+
+// LastRX returns a copy of the bytes last read from the device. After a
+// timeout this is the partial reply.
+func (st *Sabertooth) LastRX() []byte {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]byte(nil), st.lastRX...)
+}
+
+// Transaction writes the raw command cmd to the device and returns the raw
+// reply of replyLen bytes. No reply is read if replyLen is 0. It is the
+// low-level primitive for commands not covered by the other methods. The
+// reply is not decoded or checked in any way.
+func (st *Sabertooth) Transaction(cmd []byte, replyLen int) ([]byte, error) {
+	if replyLen < 0 {
+		return nil, errors.New("invalid reply length")
 	}
-	packet, err := decodePacket(data)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.transaction(cmd, replyLen)
+}
+
+// transaction writes cmd and reads the reply. On a half-duplex or echoing
+// link the reply can start arriving before the write returns. Those bytes
+// are kept in the input buffer of the port and read as the start of the
+// reply, so the input buffer must only ever be flushed before a write,
+// never between the write and the read. The caller must hold st.mu.
+func (st *Sabertooth) transaction(cmd []byte, replyLen int) ([]byte, error) {
+	err := st.acquirePort()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return int(packet.Value), nil
+	defer st.releasePort()
+	err = st.write(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if replyLen == 0 {
+		return nil, nil
+	}
+	return st.readReply(replyLen)
+}
+
+// SetControllerDeadband sets the deadband the controller applies to its
+// own inputs, in the range 0 to 127. 0 restores the default deadband.
+// Unlike host side settings the value is stored by the controller and
+// persists over power cycles.
+func (st *Sabertooth) SetControllerDeadband(value int) error {
+	if value < 0 || value > 127 {
+		return errors.New("value out of range")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, err := st.transaction(makePacket(st.address, CmdDeadband, byte(value), nil), 0)
+	return err
+}
+
+// SetControllerBaud changes the baud rate of the controller and then
+// switches the serial port to the same baud rate. Supported rates are 2400,
+// 9600, 19200, 38400 and 115200. The controller stores the setting, so the
+// new baud rate must be used also after a power cycle. If the command is
+// lost the controller keeps its old baud rate while the host has switched,
+// and communication fails until the host baud rate is set back, for example
+// by opening the port with WithBaudRate.
+func (st *Sabertooth) SetControllerBaud(baud int) error {
+	code, ok := baudRateCodes[baud]
+	if !ok {
+		return errors.New("unsupported baud rate")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, err := st.transaction(makePacket(st.address, CmdBaudRate, code, nil), 0)
+	if err != nil {
+		return err
+	}
+	return st.setBaudRate(baud)
+}
+
+// setBaudRate sets the baud rate of the port. The caller must hold st.mu.
+func (st *Sabertooth) setBaudRate(baud int) error {
+	st.baudRate = baud
+	if st.port == nil {
+		return nil
+	}
+	err := st.port.SetMode(st.mode())
+	if err != nil {
+		return err
+	}
+	return st.port.ResetInputBuffer()
+}
+
+// set sends a Set command. The device never replies to Set commands, so no
+// reply is read; reading one would consume the bytes of the next reply.
+// All Set commands must be sent through set. The caller must hold st.mu.
+func (st *Sabertooth) set(setType, target, number byte, value int16) error {
+	if st.simplified {
+		return ErrSimplifiedSerial
+	}
+	st.scratch = AppendSetCommand(st.scratch[:0], st.address, setType, target, number, value)
+	_, err := st.transaction(st.scratch, 0)
+	if err != nil {
+		return err
+	}
+	// Keepalives must not hold off the watchdog
+	if setType != CmdSetKeepalive {
+		st.lastCommand = time.Now()
+	}
+	return nil
+}
+
+// write writes p to the port, calling the pre and post transmit hooks
+// around the write. The caller must hold st.mu.
+func (st *Sabertooth) write(p []byte) error {
+	st.lastTX = append(st.lastTX[:0], p...)
+	// Checked here as well, as passing p to logf allocates
+	if st.logger != nil {
+		st.logf("tx [% x]", p)
+	}
+	st.captureFrame("tx", p)
+	if st.preTransmit != nil {
+		st.preTransmit()
+	}
+	n, err := st.port.Write(p)
+	if st.postTransmit != nil {
+		st.postTransmit()
+	}
+	if st.interFrameDelay > 0 {
+		time.Sleep(st.interFrameDelay)
+	}
+	if err != nil {
+		return &TransportError{Op: "write", Err: err}
+	}
+	if n != len(p) {
+		return errors.New("wrote unexpected number of bytes")
+	}
+	return nil
+}
+
+// readReply reads a reply of n bytes from the port. A *TimeoutError holding
+// the partial frame is returned if the reply is not complete within the
+// read timeout.
+func (st *Sabertooth) readReply(n int) ([]byte, error) {
+	data := make([]byte, n)
+	received := 0
+	defer func() {
+		st.lastRX = append(st.lastRX[:0], data[:received]...)
+		if st.logger != nil {
+			st.logf("rx [% x]", st.lastRX)
+		}
+		st.captureFrame("rx", st.lastRX)
+	}()
+	deadline := time.Now().Add(st.readTimeout)
+	for received < n {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, newTimeoutError(n, data[:received])
+		}
+		err := st.port.SetReadTimeout(remaining)
+		if err != nil {
+			return nil, &TransportError{Op: "read", Err: err}
+		}
+		m, err := st.port.Read(data[received:])
+		if err != nil {
+			return nil, &TransportError{Op: "read", Err: err}
+		}
+		if m == 0 {
+			return nil, newTimeoutError(n, data[:received])
+		}
+		received += m
+	}
+	return data, nil
+}
+
+func newTimeoutError(expected int, received []byte) error {
+	return &ProtocolError{Op: "read", Frame: received, Err: &TimeoutError{Expected: expected, Received: received}}
 }
 
 // Motor controls the motors. motor is 1 or 2. speed is between -1 and 1
@@ -133,44 +889,402 @@ func (st *Sabertooth) Motor(motor int, speed float64) error {
 	if speed < -1 || speed > 1 {
 		return errors.New("value out of range")
 	}
-	value := speed * 2047
-	n, err := st.port.Write(setCommand(st.address, CmdSetValue, 'M', byte(motor), int16(value)))
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.motor(motor, speed)
+}
+
+// motorAllowed returns the error for commanding a motor while the outputs
+// are disabled or the heartbeat is lost, and nil otherwise. The caller
+// must hold st.mu.
+func (st *Sabertooth) motorAllowed() error {
+	if st.disabled && !st.allowWhenDisabled {
+		return ErrDisabled
+	}
+	if st.heartbeatLost {
+		return ErrHeartbeatLost
+	}
+	return nil
+}
+
+func (st *Sabertooth) motor(motor int, speed float64) error {
+	err := st.motorAllowed()
 	if err != nil {
 		return err
 	}
-	if n != 9 {
-		return errors.New("wrote unexpected number of bytes")
+	ch := st.channel(motor)
+	now := time.Now()
+	if st.softStart > 0 {
+		speed = ch.step(speed, st.softStart, now)
+	}
+	output := speed
+	if ch.speedLimit > 0 {
+		output *= ch.speedLimit
+	}
+	if ch.inverted {
+		output = -output
+	}
+	if st.simplified {
+		var cmd byte
+		cmd, err = simplifiedCommand(motor, output)
+		if err != nil {
+			return err
+		}
+		err = st.sendSimplified(cmd)
+	} else {
+		err = st.set(CmdSetValue, 'M', byte(motor), Denormalize(output))
+	}
+	if err != nil {
+		return err
+	}
+	if st.verifyWrites && !st.simplified {
+		err = st.verify('M', byte(motor), Denormalize(output))
+		if err != nil {
+			return err
+		}
+	}
+	ch.lastSpeed = speed
+	ch.lastTime = now
+	return nil
+}
+
+// MotorRaw sets the speed of a motor to a raw count between -2047 and 2047,
+// bypassing the conversion from -1 to 1. It is a low level companion to
+// Motor for calibration and testing: soft start, speed limit and
+// inversion are not applied and LastSpeed is not updated.
+func (st *Sabertooth) MotorRaw(motor int, count int16) error {
+	if count < -maxValue || count > maxValue {
+		return errors.New("value out of range")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := st.motorAllowed()
+	if err != nil {
+		return err
+	}
+	return st.set(CmdSetValue, 'M', byte(motor), count)
+}
+
+// MixedDrive controls the motors in mixed mode, for differential drive.
+// drive sets the forward speed and turn the turning speed, both between -1
+// and 1 inclusive. Packet serial has no single frame for both, so the
+// drive and turn commands are sent as two frames, but in a single write so
+// that no other command can come between them.
+func (st *Sabertooth) MixedDrive(drive, turn float64) error {
+	if drive < -1 || drive > 1 || turn < -1 || turn > 1 {
+		return errors.New("value out of range")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.simplified {
+		return ErrSimplifiedSerial
+	}
+	err := st.motorAllowed()
+	if err != nil {
+		return err
+	}
+	st.scratch = AppendSetCommand(st.scratch[:0], st.address, CmdSetValue, 'M', 'D', Denormalize(drive))
+	st.scratch = AppendSetCommand(st.scratch, st.address, CmdSetValue, 'M', 'T', Denormalize(turn))
+	_, err = st.transaction(st.scratch, 0)
+	if err != nil {
+		return err
+	}
+	st.lastCommand = time.Now()
+	return nil
+}
+
+// verifyTolerance is the largest difference between a value set and read
+// back that WithVerifyWrites accepts
+const verifyTolerance = 20
+
+// verify reads back a value that was set and checks that it matches. The
+// caller must hold st.mu.
+func (st *Sabertooth) verify(target, number byte, value int16) error {
+	readBack, err := st.read(CmdGetValue, target, number)
+	if err != nil {
+		return err
+	}
+	diff := readBack - int(value)
+	if diff > verifyTolerance || diff < -verifyTolerance {
+		return fmt.Errorf("%w: set %d, read %d", ErrVerifyMismatch, value, readBack)
+	}
+	return nil
+}
+
+// Stop stops both motors immediately, bypassing soft start
+func (st *Sabertooth) Stop() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.stop()
+}
+
+// SafeGuard returns a function that stops both motors, meant to be
+// deferred so that the motors are stopped however the function returns,
+// including when it panics:
+//
+//	defer st.SafeGuard()()
+//
+// A panic continues after the motors are stopped; recover it in the caller
+// as usual. Errors from Stop are logged.
+func (st *Sabertooth) SafeGuard() func() {
+	return func() {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		err := st.stop()
+		if err != nil {
+			st.logf("safeguard stop: %v", err)
+		}
+	}
+}
+
+func (st *Sabertooth) stop() error {
+	if st.simplified {
+		err := st.sendSimplified(simplifiedStopAll)
+		if err != nil {
+			return err
+		}
+		for motor := 1; motor <= 2; motor++ {
+			st.channel(motor).stopped()
+		}
+		return nil
+	}
+	for motor := 1; motor <= 2; motor++ {
+		err := st.stopMotor(motor)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopMotor stops a motor immediately, bypassing soft start. The caller
+// must hold st.mu.
+func (st *Sabertooth) stopMotor(motor int) error {
+	err := st.set(CmdSetValue, 'M', byte(motor), 0)
+	if err != nil {
+		return err
+	}
+	st.channel(motor).stopped()
+	return nil
+}
+
+// Keepalive resets the serial timeout of the controller without changing
+// any output
+func (st *Sabertooth) Keepalive() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.set(CmdSetKeepalive, 'M', '*', 0)
+}
+
+// freewheelOn is the value of the freewheel target that enables freewheeling
+const freewheelOn = 2048
+
+// Brake stops a motor with active, regenerative braking. It turns off
+// freewheeling set by Coast and sets the speed to 0.
+func (st *Sabertooth) Brake(motor int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := st.set(CmdSetValue, 'Q', byte(motor), 0)
+	if err != nil {
+		return err
+	}
+	return st.stopMotor(motor)
+}
+
+// Coast stops driving a motor and lets it spin freely until it stops by
+// itself. It sets the speed to 0 and turns on freewheeling ('Q' target),
+// which stays on until Brake is called. Freewheeling is supported by the
+// Sabertooth 2x32 and newer controllers; a stop with speed 0 on other
+// controllers brakes.
+func (st *Sabertooth) Coast(motor int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := st.stopMotor(motor)
+	if err != nil {
+		return err
+	}
+	return st.set(CmdSetValue, 'Q', byte(motor), freewheelOn)
+}
+
+// shutdownTargets are the outputs Enable and Disable act on
+var shutdownTargets = []struct{ target, number byte }{
+	{'M', 1}, {'M', 2}, {'P', 1}, {'P', 2},
+}
+
+// Disable shuts down all motor and power outputs with the shutdown command.
+// The outputs stay off until Enable is called. While disabled Motor returns
+// ErrDisabled, unless WithAllowMotorWhenDisabled is used.
+func (st *Sabertooth) Disable() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.shutdown(true)
+}
+
+// Enable enables all motor and power outputs disabled by Disable
+func (st *Sabertooth) Enable() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.shutdown(false)
+}
+
+func (st *Sabertooth) shutdown(shutdown bool) error {
+	var value int16
+	if shutdown {
+		value = 2048
 	}
+	for _, t := range shutdownTargets {
+		err := st.set(CmdSetShutdown, t.target, t.number, value)
+		if err != nil {
+			return err
+		}
+	}
+	st.disabled = shutdown
 	return nil
 }
 
+// Enabled returns false if the outputs have been disabled with Disable
+func (st *Sabertooth) Enabled() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return !st.disabled
+}
+
+// Zero sets the value of a single output to 0, for example Power for a
+// power output, without affecting any other output. port and number
+// identify the output as in Input.
+func (st *Sabertooth) Zero(port Target, number int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.set(CmdSetValue, byte(port), byte(number), 0)
+}
+
+// MotorWithRamp sets the ramping of a motor and then its speed, without
+// any other command on the same device being sent in between. The ramping
+// command is always sent before the speed command, so the new speed is
+// approached with the new ramping. ramp is sent as is to the ramping
+// setting ('R' target) of the motor and must be between -16383 and 16383.
+func (st *Sabertooth) MotorWithRamp(motor int, speed float64, ramp int) error {
+	if speed < -1 || speed > 1 {
+		return errors.New("value out of range")
+	}
+	if ramp < -16383 || ramp > 16383 {
+		return errors.New("ramp out of range")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := st.set(CmdSetValue, 'R', byte(motor), int16(ramp))
+	if err != nil {
+		return err
+	}
+	return st.motor(motor, speed)
+}
+
 // SerialPort scans the USB serial ports for a Sabertooth.
 func SerialPort() (string, error) {
-	ports, err := enumerator.GetDetailedPortsList()
+	portDetails, err := findSabertooth()
 	if err != nil {
 		return "", err
 	}
-	if len(ports) == 0 {
-		return "", errors.New("no serial ports found")
+	return portDetails.Name, nil
+}
+
+// SerialPortGlob returns the first serial port, in sorted order, whose name
+// matches pattern, for example "/dev/ttyACM*". The pattern syntax is that
+// of filepath.Match. Unlike SerialPort it does not use USB enumeration, so
+// it works on platforms where enumeration is not supported, but it can
+// not tell a Sabertooth from other serial devices.
+func SerialPortGlob(pattern string) (string, error) {
+	ports, err := portsList()
+	if err != nil {
+		return "", err
 	}
+	sort.Strings(ports)
+	for _, port := range ports {
+		matched, err := filepath.Match(pattern, port)
+		if err != nil {
+			return "", err
+		}
+		if matched {
+			return port, nil
+		}
+	}
+	return "", errors.New("no matching serial port found")
+}
 
-	portFound := false
+// Model returns the product name the USB serial port of the device reports.
+// The packet serial protocol has no query for the model, so this is the
+// only way to confirm over the connection which hardware is attached.
+func (st *Sabertooth) Model() (string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.model != "" {
+		return st.model, nil
+	}
+	ports, err := detailedPortsList()
+	if err != nil {
+		return "", err
+	}
+	for _, portDetails := range ports {
+		if portDetails.Name == st.portName {
+			if portDetails.Product == "" {
+				return "", errors.New("port does not report a product name")
+			}
+			st.model = portDetails.Product
+			return st.model, nil
+		}
+	}
+	return "", errors.New("port not found")
+}
 
-	var portDetails *enumerator.PortDetails
+func findSabertooth() (*enumerator.PortDetails, error) {
+	ports, err := detailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+	if len(ports) == 0 {
+		return nil, errors.New("no serial ports found")
+	}
 
-	for _, portDetails = range ports {
-		// fmt.Printf("Found port: %s\n", port.Name)
-		if portDetails.IsUSB && portDetails.VID == "268B" && portDetails.PID == "0201" {
-			//fmt.Printf("Found Sabertooth on port %s\n", portDetails.Name)
-			portFound = true
-			break
+	for _, portDetails := range ports {
+		if isSabertooth(portDetails) {
+			return portDetails, nil
 		}
 	}
-	if !portFound {
-		return "", errors.New("sabertooth not found")
-	}
+	return nil, errors.New("sabertooth not found")
+}
 
-	return portDetails.Name, nil
+func isSabertooth(portDetails *enumerator.PortDetails) bool {
+	return portDetails.IsUSB && portDetails.VID == "268B" && portDetails.PID == "0201"
+}
+
+// PortInfo describes a serial port
+type PortInfo struct {
+	Name         string
+	VID          string
+	PID          string
+	Product      string
+	IsSabertooth bool
+}
+
+// ListPorts lists all serial ports, for example to let a user pick the
+// port of a device. IsSabertooth is set for the ports SerialPort would
+// pick.
+func ListPorts() ([]PortInfo, error) {
+	ports, err := detailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]PortInfo, len(ports))
+	for i, portDetails := range ports {
+		infos[i] = PortInfo{
+			Name:         portDetails.Name,
+			VID:          portDetails.VID,
+			PID:          portDetails.PID,
+			Product:      portDetails.Product,
+			IsSabertooth: isSabertooth(portDetails),
+		}
+	}
+	return infos, nil
 }
 
 func makePacket(address, command, value byte, data []byte) []byte {
@@ -178,34 +1292,85 @@ func makePacket(address, command, value byte, data []byte) []byte {
 	if len(data) > 0 {
 		size += len(data) + 1
 	}
-	packet := make([]byte, size)
+	return appendPacket(make([]byte, 0, size), address, command, value, data)
+}
 
-	packet[0] = address
-	packet[1] = command
-	packet[2] = value
-	packet[3] = (address + command + value) & 0x7f
-	//packet[3] = crc7(packet[:3])
+// appendPacket is like makePacket but appends the packet to dst, so that
+// a buffer can be reused between packets
+func appendPacket(dst []byte, address, command, value byte, data []byte) []byte {
+	dst = append(dst, address, command, value, (address+command+value)&0x7f)
+	//dst = append(dst, crc7([]byte{address, command, value}))
 
 	if len(data) > 0 {
 		var checksum byte
 		for i := 0; i < len(data); i++ {
-			packet[4+i] = data[i]
 			checksum += data[i]
 		}
-		packet[4+len(data)] = checksum & 0x7f
+		dst = append(dst, data...)
+		dst = append(dst, checksum&0x7f)
 	}
 
-	return packet
+	return dst
+}
+
+// ValueDecoder decodes the magnitude of the value of a reply from its two
+// value bytes. The sign is handled separately.
+type ValueDecoder func(data []byte) int16
+
+// DecodeValue is the default ValueDecoder. The value is sent as two 7-bit
+// bytes with the least significant byte first.
+func DecodeValue(data []byte) int16 {
+	return int16(data[0]) + int16(data[1])<<7
+}
+
+// ReplyLength is the length in bytes of a standard reply with the simple
+// checksum
+const ReplyLength = 9
+
+// replyLengths holds the reply length of the Get types whose reply differs
+// from ReplyLength
+var replyLengths = map[byte]int{}
+
+// replyLength returns the expected length in bytes of the reply to a Get
+// command of the given type
+func replyLength(getType byte) int {
+	if n, ok := replyLengths[getType]; ok {
+		return n
+	}
+	return ReplyLength
 }
 
-func decodePacket(data []byte) (*Packet, error) {
+// decodePacket decodes a reply packet. The sign of the value is carried in
+// the least significant bit of the Get type. A reply with the sign bit set
+// and a zero magnitude decodes to 0 with the sign bit cleared from Target,
+// so zero always has a single representation in a decoded Packet.
+func decodePacket(data []byte, decodeValue ValueDecoder) (*Packet, error) {
 	//log.Printf("%v", data)
 	packet := Packet{}
+	if len(data) < ReplyLength {
+		return nil, &ProtocolError{Op: "decode", Frame: data, Err: ErrShortPacket}
+	}
 	if data[1] != CmdReply {
-		return nil, errors.New("unexpected command type")
+		return nil, &ProtocolError{Op: "decode", Frame: data, Err: ErrUnexpectedCommand}
+	}
+	// Only the address may have the high bit set
+	for _, b := range data[1:] {
+		if b&0x80 != 0 {
+			return nil, &ProtocolError{Op: "decode", Frame: data, Err: ErrInvalidByte}
+		}
+	}
+	if (data[0]+data[1]+data[2])&0x7f != data[3] {
+		return nil, &ProtocolError{Op: "decode", Frame: data, Err: ErrHeaderChecksum}
+	}
+	var checksum byte
+	for _, b := range data[4 : len(data)-1] {
+		checksum += b
+	}
+	if checksum&0x7f != data[len(data)-1] {
+		return nil, &ProtocolError{Op: "decode", Frame: data, Err: ErrDataChecksum}
 	}
 	packet.Address = data[0]
-	packet.Value = int16(data[4]) + int16(data[5])<<7
+	packet.Value = decodeValue(data[4:6])
 	packet.Target = data[2]
 	if packet.Target&1 == 1 {
 		packet.Value = -packet.Value
@@ -217,8 +1382,48 @@ func decodePacket(data []byte) (*Packet, error) {
 	return &packet, nil
 }
 
+func getTypeName(getType byte) string {
+	switch getType {
+	case CmdGetValue:
+		return "value"
+	case CmdGetBattery:
+		return "battery"
+	case CmdGetCurrent:
+		return "current"
+	case CmdGetTemp:
+		return "temperature"
+	}
+	return "unknown"
+}
+
+func sourceTypeName(sourceType byte) string {
+	switch Target(sourceType) {
+	case Motor:
+		return "motor"
+	case Power:
+		return "power"
+	case Signal:
+		return "signal"
+	case Aux:
+		return "aux"
+	}
+	return "unknown"
+}
+
+// setCommand encodes a Set command. Negative values are sent as their
+// magnitude with the least significant bit of the set type set. Zero is
+// always encoded as positive, i.e. the set type is never incremented for 0.
 func setCommand(address, setType, targetType, targetNumber byte, value int16) []byte {
-	data := make([]byte, 4)
+	return AppendSetCommand(make([]byte, 0, 9), address, setType, targetType, targetNumber, value)
+}
+
+// AppendSetCommand appends the packet serial Set command setting the
+// target, for example 'M' and 1 for motor 1, to value to dst and returns
+// the extended buffer. setType is one of the CmdSet types, such as
+// CmdSetValue. It lets commands be encoded without a device, for example
+// to send them through a bridge.
+func AppendSetCommand(dst []byte, address, setType, targetType, targetNumber byte, value int16) []byte {
+	var data [4]byte
 
 	data[2] = targetType
 	data[3] = targetNumber
@@ -228,15 +1433,20 @@ func setCommand(address, setType, targetType, targetNumber byte, value int16) []
 	}
 	data[0] = byte(value & 0x7f)
 	data[1] = byte((value >> 7) & 0x7f)
-	return makePacket(address, CmdSet, setType, data)
+	return appendPacket(dst, address, CmdSet, setType, data[:])
 }
 
 func getCommand(address, getType, sourceType, sourceNumber byte) []byte {
+	return AppendGetCommand(make([]byte, 0, 7), address, getType, sourceType, sourceNumber)
+}
 
-	data := make([]byte, 2)
-	data[0] = sourceType
-	data[1] = sourceNumber
-	return makePacket(address, CmdGet, getType, data)
+// AppendGetCommand appends the packet serial Get command reading the
+// source, for example 'M' and 1 for motor 1, to dst and returns the
+// extended buffer. getType is one of the CmdGet types, such as
+// CmdGetBattery. For those the device replies with a packet of
+// ReplyLength bytes.
+func AppendGetCommand(dst []byte, address, getType, sourceType, sourceNumber byte) []byte {
+	return appendPacket(dst, address, CmdGet, getType, []byte{sourceType, sourceNumber})
 }
 
 func crc7(data []byte) byte {
@@ -0,0 +1,1155 @@
+package sabertooth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// fakePort is a serial.Port standing in for a device. Every write is
+// recorded, and each Get command written is answered with the next of
+// replies, or by respond if it is set. Reads return the pending reply
+// bytes, at most chunk at a time if it is set, and report a timeout, no
+// data and no error, after the read timeout when there are none. Writes
+// take delay.
+type fakePort struct {
+	mu          sync.Mutex
+	chunk       int
+	writes      [][]byte
+	rx          []byte
+	replies     [][]byte
+	respond     func(cmd []byte) []byte
+	readErr     error
+	writeErr    error
+	resets      int
+	reads       int
+	readTimeout time.Duration
+	delay       time.Duration
+	mode        serial.Mode
+	closed      bool
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reads++
+	if p.readErr != nil {
+		return 0, p.readErr
+	}
+	if len(p.rx) == 0 {
+		// Wait for the read timeout like a real port
+		timeout := p.readTimeout
+		p.mu.Unlock()
+		time.Sleep(timeout)
+		p.mu.Lock()
+	}
+	if p.chunk > 0 && len(b) > p.chunk {
+		b = b[:p.chunk]
+	}
+	n := copy(b, p.rx)
+	p.rx = p.rx[n:]
+	return n, nil
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	// Take as long as a write over a slow link
+	time.Sleep(p.delay)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writeErr != nil {
+		return 0, p.writeErr
+	}
+	p.writes = append(p.writes, append([]byte(nil), b...))
+	if len(b) < 2 || b[1] != CmdGet {
+		return len(b), nil
+	}
+	if p.respond != nil {
+		p.rx = append(p.rx, p.respond(b)...)
+	} else if len(p.replies) > 0 {
+		p.rx = append(p.rx, p.replies[0]...)
+		p.replies = p.replies[1:]
+	}
+	return len(b), nil
+}
+
+func (p *fakePort) SetMode(mode *serial.Mode) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mode = *mode
+	return nil
+}
+
+func (p *fakePort) ResetInputBuffer() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rx = nil
+	p.resets++
+	return nil
+}
+
+func (p *fakePort) ResetOutputBuffer() error {
+	return nil
+}
+
+func (p *fakePort) SetDTR(dtr bool) error {
+	return nil
+}
+
+func (p *fakePort) SetRTS(rts bool) error {
+	return nil
+}
+
+func (p *fakePort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+
+func (p *fakePort) SetReadTimeout(t time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readTimeout = t
+	return nil
+}
+
+func (p *fakePort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// written returns the writes recorded so far
+func (p *fakePort) written() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte(nil), p.writes...)
+}
+
+// queue adds replies to the Get commands to come
+func (p *fakePort) queue(replies ...[]byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replies = append(p.replies, replies...)
+}
+
+// newFake returns a device at address 128 on a fake port, with a short read
+// timeout so that missing replies fail fast
+func newFake(opts ...Option) (*Sabertooth, *fakePort) {
+	port := &fakePort{}
+	st := NewSabertoothWithPort(128, port, opts...)
+	st.SetReadTimeout(20 * time.Millisecond)
+	return st, port
+}
+
+// reply encodes the reply of the device at address to a Get of getType for
+// the source, as the device sends it
+func reply(address, getType byte, value int, source byte, number byte) []byte {
+	if value < 0 {
+		value = -value
+		getType++
+	}
+	data := []byte{byte(value & 0x7f), byte(value >> 7 & 0x7f), source, number}
+	return makePacket(address, CmdReply, getType, data)
+}
+
+func expectWrites(t *testing.T, port *fakePort, want ...[]byte) {
+	t.Helper()
+	got := port.written()
+	if len(got) != len(want) {
+		t.Fatalf("got %d writes [% x], want %d [% x]", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("write %d: got [% x], want [% x]", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDecodePacketZeroWithSignBit(t *testing.T) {
+	// A negative zero: magnitude 0 with the sign bit of the Get type set
+	data := makePacket(128, CmdReply, CmdGetValue+1, []byte{0, 0, 'M', 1})
+	packet, err := decodePacket(data, DecodeValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Value != 0 {
+		t.Errorf("value %d, want 0", packet.Value)
+	}
+	if packet.Target != CmdGetValue {
+		t.Errorf("target %d, want %d", packet.Target, CmdGetValue)
+	}
+	positive, err := decodePacket(makePacket(128, CmdReply, CmdGetValue, []byte{0, 0, 'M', 1}), DecodeValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *packet != *positive {
+		t.Errorf("negative zero decodes to %+v, positive zero to %+v", *packet, *positive)
+	}
+}
+
+func TestSetCommandZeroIsPositive(t *testing.T) {
+	cmd := setCommand(128, CmdSetValue, 'M', 1, 0)
+	if cmd[2] != CmdSetValue {
+		t.Errorf("set type %d, want %d", cmd[2], CmdSetValue)
+	}
+	cmd = setCommand(128, CmdSetValue, 'M', 1, -1)
+	if cmd[2] != CmdSetValue+1 {
+		t.Errorf("set type of -1 is %d, want %d", cmd[2], CmdSetValue+1)
+	}
+}
+
+func TestReadZeroWithSignBit(t *testing.T) {
+	st, port := newFake()
+	port.queue(makePacket(128, CmdReply, CmdGetValue+1, []byte{0, 0, 'S', 1}))
+	value, err := st.Read(CmdGetValue, 'S', 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 0 {
+		t.Errorf("value %d, want 0", value)
+	}
+}
+
+func TestInputPercent(t *testing.T) {
+	tests := []struct {
+		raw  int
+		want float64
+	}{
+		{0, 0},
+		{2047, 100},
+		{-2047, -100},
+		{1024, 1024.0 / 2047 * 100},
+	}
+	for _, test := range tests {
+		st, port := newFake()
+		port.queue(reply(128, CmdGetValue, test.raw, 'A', 2))
+		got, err := st.InputPercent(Aux, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("InputPercent of %d = %v, want %v", test.raw, got, test.want)
+		}
+		expectWrites(t, port, getCommand(128, CmdGetValue, 'A', 2))
+	}
+}
+
+func TestReplyLength(t *testing.T) {
+	if n := replyLength(CmdGetBattery); n != ReplyLength {
+		t.Errorf("battery reply length %d, want %d", n, ReplyLength)
+	}
+	const getIdentity = 0x70
+	replyLengths[getIdentity] = 14
+	defer delete(replyLengths, getIdentity)
+	if n := replyLength(getIdentity); n != 14 {
+		t.Fatalf("reply length %d, want 14", n)
+	}
+
+	st, port := newFake()
+	port.chunk = 3
+	long := []byte("0123456789abcdef")
+	port.queue(long)
+	got, err := st.Transaction(getCommand(128, getIdentity, 'M', 1), replyLength(getIdentity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, long[:14]) {
+		t.Errorf("got [% x], want [% x]", got, long[:14])
+	}
+}
+
+func TestDecodePacketChecksums(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(frame []byte)
+		want    error
+	}{
+		{"valid", func(frame []byte) {}, nil},
+		{"header value", func(frame []byte) { frame[2] ^= 0x20 }, ErrHeaderChecksum},
+		{"header checksum", func(frame []byte) { frame[3] ^= 0x01 }, ErrHeaderChecksum},
+		{"data value", func(frame []byte) { frame[4] ^= 0x01 }, ErrDataChecksum},
+		{"data source", func(frame []byte) { frame[7] = 2 }, ErrDataChecksum},
+		{"data checksum", func(frame []byte) { frame[8] ^= 0x01 }, ErrDataChecksum},
+	}
+	for _, test := range tests {
+		frame := reply(128, CmdGetBattery, 121, 'M', 1)
+		test.corrupt(frame)
+		_, err := decodePacket(frame, DecodeValue)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestSetCommandsReadNoReply(t *testing.T) {
+	st, port := newFake()
+	calls := []func() error{
+		func() error { return st.Motor(1, 0.5) },
+		func() error { return st.MotorRaw(2, -100) },
+		func() error { return st.SetValue(Power, 1, 0.25) },
+		func() error { return st.Keepalive() },
+		func() error { return st.Stop() },
+	}
+	for _, call := range calls {
+		err := call()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if port.reads != 0 {
+		t.Errorf("Set commands read %d times, want 0", port.reads)
+	}
+	port.queue(reply(128, CmdGetBattery, 122, 'M', 1))
+	bat, err := st.Battery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bat != 12.2 {
+		t.Errorf("battery %v, want 12.2", bat)
+	}
+}
+
+func TestSetControllerDeadband(t *testing.T) {
+	st, port := newFake()
+	for _, value := range []int{0, 20, 127} {
+		err := st.SetControllerDeadband(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, value := range []int{-1, 128} {
+		if err := st.SetControllerDeadband(value); err == nil {
+			t.Errorf("deadband %d accepted", value)
+		}
+	}
+	expectWrites(t, port,
+		[]byte{128, CmdDeadband, 0, 17},
+		[]byte{128, CmdDeadband, 20, 37},
+		[]byte{128, CmdDeadband, 127, 16},
+	)
+}
+
+// benchPort is a fakePort that does not record writes, and answers each
+// Get with the same reply, so that it does not allocate itself
+type benchPort struct {
+	fakePort
+	reply []byte
+	rx    []byte
+}
+
+func (p *benchPort) Write(b []byte) (int, error) {
+	if b[1] == CmdGet {
+		p.rx = p.reply
+	}
+	return len(b), nil
+}
+
+func (p *benchPort) Read(b []byte) (int, error) {
+	n := copy(b, p.rx)
+	p.rx = p.rx[n:]
+	return n, nil
+}
+
+func BenchmarkMotor(b *testing.B) {
+	st := NewSabertoothWithPort(128, &benchPort{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := st.Motor(1, 0.5)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	st := NewSabertoothWithPort(128, &benchPort{reply: reply(128, CmdGetBattery, 120, 'M', 1)})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := st.Read(CmdGetBattery, 'M', 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMotorWithRamp(t *testing.T) {
+	st, port := newFake()
+	err := st.MotorWithRamp(2, -1, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'R', 2, 300),
+		setCommand(128, CmdSetValue, 'M', 2, -2047),
+	)
+	if err := st.MotorWithRamp(1, 1.5, 0); err == nil {
+		t.Error("speed out of range accepted")
+	}
+	if err := st.MotorWithRamp(1, 0, 16384); err == nil {
+		t.Error("ramp out of range accepted")
+	}
+}
+
+func TestReadCheckedRetriesBadChecksum(t *testing.T) {
+	st, port := newFake()
+	bad := reply(128, CmdGetCurrent, 35, 'M', 2)
+	bad[8] ^= 0x01
+	port.queue(bad, reply(128, CmdGetCurrent, 35, 'M', 2))
+	value, err := st.ReadChecked(CmdGetCurrent, 'M', 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 35 {
+		t.Errorf("value %d, want 35", value)
+	}
+	if n := len(port.written()); n != 2 {
+		t.Errorf("%d commands sent, want 2", n)
+	}
+}
+
+func TestReadCheckedRetriesOnce(t *testing.T) {
+	st, port := newFake()
+	bad := reply(128, CmdGetCurrent, 35, 'M', 2)
+	bad[3] ^= 0x01
+	port.queue(bad, bad, reply(128, CmdGetCurrent, 35, 'M', 2))
+	_, err := st.ReadChecked(CmdGetCurrent, 'M', 2)
+	if !errors.Is(err, ErrHeaderChecksum) {
+		t.Fatalf("got %v, want ErrHeaderChecksum", err)
+	}
+	if n := len(port.written()); n != 2 {
+		t.Errorf("%d commands sent, want 2", n)
+	}
+}
+
+func TestReadResyncsShiftedStream(t *testing.T) {
+	st, port := newFake()
+	// Two stray bytes, for example the tail of an earlier reply
+	shifted := append([]byte{0x12, 0x34}, reply(128, CmdGetTemp, 41, 'M', 1)...)
+	port.queue(shifted, reply(128, CmdGetTemp, 42, 'M', 1))
+	for _, want := range []int{41, 42} {
+		temp, err := st.Temp(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if temp != want {
+			t.Errorf("temp %d, want %d", temp, want)
+		}
+	}
+}
+
+func TestReadResyncFails(t *testing.T) {
+	st, port := newFake()
+	port.queue([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9})
+	_, err := st.Temp(1)
+	if !errors.Is(err, ErrUnexpectedCommand) {
+		t.Errorf("got %v, want ErrUnexpectedCommand", err)
+	}
+}
+
+func TestBatteryPercent(t *testing.T) {
+	tests := []struct {
+		tenths int
+		want   float64
+	}{
+		{110, 0},
+		{100, 0},
+		{120, 25},
+		{140, 75},
+		{150, 100},
+		{160, 100},
+	}
+	for _, test := range tests {
+		st, port := newFake()
+		port.queue(reply(128, CmdGetBattery, test.tenths, 'M', 1))
+		got, err := st.BatteryPercent(11, 15)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%d tenths of a volt: %v%%, want %v%%", test.tenths, got, test.want)
+		}
+	}
+	st, _ := newFake()
+	if _, err := st.BatteryPercent(15, 11); err == nil {
+		t.Error("inverted voltage range accepted")
+	}
+}
+
+func TestZero(t *testing.T) {
+	st, port := newFake()
+	err := st.Zero(Power, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, port, []byte{128, CmdSet, CmdSetValue, 0x28, 0, 0, 'P', 2, 0x52})
+}
+
+func TestDisableEnable(t *testing.T) {
+	st, port := newFake()
+	err := st.Disable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Enabled() {
+		t.Error("enabled after Disable")
+	}
+	if err := st.Motor(1, 0.5); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Motor while disabled: got %v, want ErrDisabled", err)
+	}
+	err = st.Enable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Enabled() {
+		t.Error("disabled after Enable")
+	}
+	err = st.Motor(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want [][]byte
+	for _, value := range []int16{2048, 0} {
+		for _, target := range []struct{ target, number byte }{{'M', 1}, {'M', 2}, {'P', 1}, {'P', 2}} {
+			want = append(want, setCommand(128, CmdSetShutdown, target.target, target.number, value))
+		}
+	}
+	want = append(want, setCommand(128, CmdSetValue, 'M', 1, 1024))
+	expectWrites(t, port, want...)
+}
+
+func TestAllowMotorWhenDisabled(t *testing.T) {
+	st, port := newFake(WithAllowMotorWhenDisabled(true))
+	err := st.Disable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Motor(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := port.written()
+	if got, want := writes[len(writes)-1], setCommand(128, CmdSetValue, 'M', 1, 1024); !bytes.Equal(got, want) {
+		t.Errorf("got [% x], want [% x]", got, want)
+	}
+}
+
+// Frames worked out by hand from the packet serial format: address,
+// command, value, header checksum (the sum of the first three bytes & 127),
+// then the data bytes and the data checksum (their sum & 127). The sign of
+// a value is carried in the least significant bit of the Set or Get type.
+var setVectors = []struct {
+	name             string
+	address, setType byte
+	target, number   byte
+	value            int16
+	frame            []byte
+}{
+	{"M1 full forward", 128, CmdSetValue, 'M', 1, 2047, []byte{0x80, 0x28, 0x00, 0x28, 0x7f, 0x0f, 0x4d, 0x01, 0x5c}},
+	{"M2 full reverse", 128, CmdSetValue, 'M', 2, -2047, []byte{0x80, 0x28, 0x01, 0x29, 0x7f, 0x0f, 0x4d, 0x02, 0x5d}},
+	{"M1 stop at 130", 130, CmdSetValue, 'M', 1, 0, []byte{0x82, 0x28, 0x00, 0x2a, 0x00, 0x00, 0x4d, 0x01, 0x4e}},
+	{"M1 1000", 128, CmdSetValue, 'M', 1, 1000, []byte{0x80, 0x28, 0x00, 0x28, 0x68, 0x07, 0x4d, 0x01, 0x3d}},
+	{"P1 shutdown", 128, CmdSetShutdown, 'P', 1, 2048, []byte{0x80, 0x28, 0x20, 0x48, 0x00, 0x10, 0x50, 0x01, 0x61}},
+}
+
+var getVectors = []struct {
+	name             string
+	address, getType byte
+	source, number   byte
+	frame            []byte
+}{
+	{"battery", 128, CmdGetBattery, 'M', 1, []byte{0x80, 0x29, 0x10, 0x39, 0x4d, 0x01, 0x4e}},
+	{"current M2 at 129", 129, CmdGetCurrent, 'M', 2, []byte{0x81, 0x29, 0x20, 0x4a, 0x4d, 0x02, 0x4f}},
+	{"temperature", 128, CmdGetTemp, 'M', 1, []byte{0x80, 0x29, 0x40, 0x69, 0x4d, 0x01, 0x4e}},
+}
+
+var replyVectors = []struct {
+	name   string
+	frame  []byte
+	packet Packet
+}{
+	{"battery 12.0 V", []byte{0x80, 0x49, 0x10, 0x59, 0x78, 0x00, 0x4d, 0x01, 0x46}, Packet{128, CmdGetBattery, 'M', 1, 120}},
+	{"current M2 -3.5 A", []byte{0x80, 0x49, 0x21, 0x6a, 0x23, 0x00, 0x4d, 0x02, 0x72}, Packet{128, CmdGetCurrent, 'M', 2, -35}},
+	{"S1 full scale", []byte{0x80, 0x49, 0x00, 0x49, 0x7f, 0x0f, 0x53, 0x01, 0x62}, Packet{128, CmdGetValue, 'S', 1, 2047}},
+}
+
+func TestSetCommandVectors(t *testing.T) {
+	for _, v := range setVectors {
+		got := setCommand(v.address, v.setType, v.target, v.number, v.value)
+		if !bytes.Equal(got, v.frame) {
+			t.Errorf("%s: got [% x], want [% x]", v.name, got, v.frame)
+		}
+		prefix := []byte{0xaa}
+		got = AppendSetCommand(prefix, v.address, v.setType, v.target, v.number, v.value)
+		if !bytes.Equal(got[1:], v.frame) || got[0] != 0xaa {
+			t.Errorf("%s: appended [% x], want aa [% x]", v.name, got, v.frame)
+		}
+	}
+}
+
+func TestGetCommandVectors(t *testing.T) {
+	for _, v := range getVectors {
+		got := getCommand(v.address, v.getType, v.source, v.number)
+		if !bytes.Equal(got, v.frame) {
+			t.Errorf("%s: got [% x], want [% x]", v.name, got, v.frame)
+		}
+	}
+}
+
+func TestDecodePacketVectors(t *testing.T) {
+	for _, v := range replyVectors {
+		got, err := decodePacket(v.frame, DecodeValue)
+		if err != nil {
+			t.Errorf("%s: %v", v.name, err)
+			continue
+		}
+		if *got != v.packet {
+			t.Errorf("%s: got %+v, want %+v", v.name, *got, v.packet)
+		}
+	}
+}
+
+func TestChecksumVectors(t *testing.T) {
+	for _, v := range setVectors {
+		f := v.frame
+		if header := (f[0] + f[1] + f[2]) & 0x7f; header != f[3] {
+			t.Errorf("%s: header checksum %#x, frame has %#x", v.name, header, f[3])
+		}
+		var data byte
+		for _, b := range f[4 : len(f)-1] {
+			data += b
+		}
+		if data&0x7f != f[len(f)-1] {
+			t.Errorf("%s: data checksum %#x, frame has %#x", v.name, data&0x7f, f[len(f)-1])
+		}
+	}
+}
+
+func TestSignBit(t *testing.T) {
+	for _, value := range []int16{1, 100, 2047} {
+		pos := setCommand(128, CmdSetValue, 'M', 1, value)
+		neg := setCommand(128, CmdSetValue, 'M', 1, -value)
+		if neg[2] != pos[2]|1 {
+			t.Errorf("%d: set type %#x, want %#x", value, neg[2], pos[2]|1)
+		}
+		if !bytes.Equal(pos[4:8], neg[4:8]) {
+			t.Errorf("%d: magnitude [% x] differs from [% x]", value, neg[4:8], pos[4:8])
+		}
+		packet, err := decodePacket(reply(128, CmdGetValue, -int(value), 'M', 1), DecodeValue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packet.Value != -value || packet.Target != CmdGetValue {
+			t.Errorf("%d: decoded %+v", -value, *packet)
+		}
+	}
+}
+
+func TestMixedDrive(t *testing.T) {
+	st, port := newFake()
+	err := st.MixedDrive(0.5, -0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := append(setCommand(128, CmdSetValue, 'M', 'D', 1024), setCommand(128, CmdSetValue, 'M', 'T', -512)...)
+	expectWrites(t, port, frames)
+	if err := st.MixedDrive(1.5, 0); err == nil {
+		t.Error("drive out of range accepted")
+	}
+}
+
+func TestVerifyWrites(t *testing.T) {
+	st, port := newFake(WithVerifyWrites(true))
+	port.queue(reply(128, CmdGetValue, 1030, 'M', 1), reply(128, CmdGetValue, 0, 'M', 1))
+	err := st.Motor(1, 0.5)
+	if err != nil {
+		t.Fatalf("readback within tolerance: %v", err)
+	}
+	expectWrites(t, port, setCommand(128, CmdSetValue, 'M', 1, 1024), getCommand(128, CmdGetValue, 'M', 1))
+	err = st.Motor(1, 0.5)
+	if !errors.Is(err, ErrVerifyMismatch) {
+		t.Errorf("got %v, want ErrVerifyMismatch", err)
+	}
+}
+
+func TestVerifyWritesSetValue(t *testing.T) {
+	st, port := newFake(WithVerifyWrites(true))
+	port.queue(reply(128, CmdGetValue, -2047, 'M', 2))
+	err := st.SetValue(Motor, 2, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, port, setCommand(128, CmdSetValue, 'M', 2, -2047), getCommand(128, CmdGetValue, 'M', 2))
+}
+
+func TestLastTXRX(t *testing.T) {
+	st, port := newFake()
+	if st.LastTX() != nil || st.LastRX() != nil {
+		t.Error("frames recorded before any command")
+	}
+	r := reply(128, CmdGetBattery, 120, 'M', 1)
+	port.queue(r)
+	_, err := st.Battery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx, want := st.LastTX(), getCommand(128, CmdGetBattery, 'M', 1); !bytes.Equal(tx, want) {
+		t.Errorf("last TX [% x], want [% x]", tx, want)
+	}
+	rx := st.LastRX()
+	if !bytes.Equal(rx, r) {
+		t.Errorf("last RX [% x], want [% x]", rx, r)
+	}
+	rx[0] = 0
+	if st.LastRX()[0] != 128 {
+		t.Error("LastRX does not return a copy")
+	}
+	err = st.Motor(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx, want := st.LastTX(), setCommand(128, CmdSetValue, 'M', 1, 2047); !bytes.Equal(tx, want) {
+		t.Errorf("last TX [% x], want [% x]", tx, want)
+	}
+}
+
+func TestReadRejectsShortReply(t *testing.T) {
+	full := reply(128, CmdGetBattery, 120, 'M', 1)
+	for n := 1; n < ReplyLength; n++ {
+		st, port := newFake()
+		port.queue(full[:n])
+		_, err := st.Read(CmdGetBattery, 'M', 1)
+		if err == nil {
+			t.Errorf("reply of %d bytes accepted", n)
+		}
+		if _, err := decodePacket(full[:n], DecodeValue); !errors.Is(err, ErrShortPacket) {
+			t.Errorf("decoding %d bytes: got %v, want ErrShortPacket", n, err)
+		}
+	}
+}
+
+func TestBrakeCoast(t *testing.T) {
+	st, port := newFake(WithSoftStart(0.1))
+	err := st.Coast(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Brake(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 1, 0),
+		setCommand(128, CmdSetValue, 'Q', 1, 2048),
+		setCommand(128, CmdSetValue, 'Q', 2, 0),
+		setCommand(128, CmdSetValue, 'M', 2, 0),
+	)
+}
+
+func TestDenormalize(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want int16
+	}{
+		{0, 0},
+		{1, 2047},
+		{-1, -2047},
+		{2, 2047},
+		{-2, -2047},
+		{0.5, 1024},
+		{-0.5, -1024},
+		{0.25, 512},
+		{-0.25, -512},
+		{1.0 / 2047, 1},
+		{0.4 / 2047, 0},
+		{0.6 / 2047, 1},
+	}
+	for _, test := range tests {
+		if got := Denormalize(test.v); got != test.want {
+			t.Errorf("Denormalize(%v) = %d, want %d", test.v, got, test.want)
+		}
+	}
+}
+
+func TestDenormalizeSymmetric(t *testing.T) {
+	for i := 0; i <= 1000; i++ {
+		v := float64(i) / 1000
+		if pos, neg := Denormalize(v), Denormalize(-v); pos != -neg {
+			t.Errorf("Denormalize(%v) = %d, Denormalize(%v) = %d", v, pos, -v, neg)
+		}
+	}
+}
+
+func TestReadWithTimeoutIsScoped(t *testing.T) {
+	st, port := newFake()
+	start := time.Now()
+	_, err := st.ReadWithTimeout(100*time.Millisecond, CmdGetBattery, 'M', 1)
+	if err == nil {
+		t.Fatal("read without reply succeeded")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("timed out after %v, want at least 100ms", elapsed)
+	}
+	start = time.Now()
+	_, err = st.Read(CmdGetBattery, 'M', 1)
+	if err == nil {
+		t.Fatal("read without reply succeeded")
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("later read timed out after %v, the scoped timeout leaked", elapsed)
+	}
+	port.queue(reply(128, CmdGetBattery, 120, 'M', 1))
+	value, err := st.ReadWithTimeout(time.Second, CmdGetBattery, 'M', 1)
+	if err != nil || value != 120 {
+		t.Errorf("got %d, %v, want 120", value, err)
+	}
+}
+
+func TestRCInput(t *testing.T) {
+	st, port := newFake()
+	port.queue(reply(128, CmdGetValue, -1024, 'S', 2))
+	got, err := st.RCInput(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := -1024.0 / 2047; got != want {
+		t.Errorf("RCInput(2) = %v, want %v", got, want)
+	}
+	expectWrites(t, port, getCommand(128, CmdGetValue, 'S', 2))
+}
+
+func TestInputInvalid(t *testing.T) {
+	tests := []struct {
+		port Target
+		n    int
+	}{
+		{'X', 1},
+		{Signal, 0},
+		{Aux, 3},
+		{Power, -1},
+	}
+	for _, test := range tests {
+		st, port := newFake()
+		if _, err := st.Input(test.port, test.n); err == nil {
+			t.Errorf("Input(%q, %d) accepted", rune(test.port), test.n)
+		}
+		if _, err := st.InputVoltage(test.port, test.n, 5); err == nil {
+			t.Errorf("InputVoltage(%q, %d) accepted", rune(test.port), test.n)
+		}
+		// Nothing is sent, the device would not reply
+		expectWrites(t, port)
+	}
+}
+
+func TestRetriesPartialThenComplete(t *testing.T) {
+	st, port := newFake(WithRetries(2))
+	full := reply(128, CmdGetCurrent, 35, 'M', 1)
+	port.queue(full[:4], full)
+	value, err := st.Read(CmdGetCurrent, 'M', 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 35 {
+		t.Errorf("value %d, want 35", value)
+	}
+	cmd := getCommand(128, CmdGetCurrent, 'M', 1)
+	expectWrites(t, port, cmd, cmd)
+	if port.resets != 1 {
+		t.Errorf("input flushed %d times, want 1", port.resets)
+	}
+}
+
+func TestRetriesPersistentPartial(t *testing.T) {
+	st, port := newFake(WithRetries(2))
+	partial := reply(128, CmdGetCurrent, 35, 'M', 1)[:4]
+	port.queue(partial, partial, partial, partial)
+	_, err := st.Read(CmdGetCurrent, 'M', 1)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a TimeoutError", err)
+	}
+	if !bytes.Equal(timeoutErr.Received, partial) {
+		t.Errorf("received [% x], want [% x]", timeoutErr.Received, partial)
+	}
+	cmd := getCommand(128, CmdGetCurrent, 'M', 1)
+	expectWrites(t, port, cmd, cmd, cmd)
+	if port.resets != 2 {
+		t.Errorf("input flushed %d times, want 2", port.resets)
+	}
+}
+
+func TestRetriesSkipChecksumErrors(t *testing.T) {
+	st, port := newFake(WithRetries(2))
+	bad := reply(128, CmdGetCurrent, 35, 'M', 1)
+	bad[8] ^= 0x01
+	port.queue(bad, reply(128, CmdGetCurrent, 35, 'M', 1))
+	_, err := st.Read(CmdGetCurrent, 'M', 1)
+	if !errors.Is(err, ErrDataChecksum) {
+		t.Fatalf("got %v, want ErrDataChecksum", err)
+	}
+	expectWrites(t, port, getCommand(128, CmdGetCurrent, 'M', 1))
+}
+
+func TestMotorRaw(t *testing.T) {
+	st, port := newFake()
+	for _, count := range []int16{2047, -2047, 0, 1} {
+		err := st.MotorRaw(2, count)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectWrites(t, port,
+		[]byte{128, CmdSet, CmdSetValue, (128 + CmdSet + CmdSetValue) & 0x7f, 0x7f, 0x0f, 'M', 2, ('M' + 2 + 0x7f + 0x0f) & 0x7f},
+		[]byte{128, CmdSet, CmdSetValue + 1, (128 + CmdSet + CmdSetValue + 1) & 0x7f, 0x7f, 0x0f, 'M', 2, ('M' + 2 + 0x7f + 0x0f) & 0x7f},
+		setCommand(128, CmdSetValue, 'M', 2, 0),
+		setCommand(128, CmdSetValue, 'M', 2, 1),
+	)
+}
+
+func TestMotorRawOutOfRange(t *testing.T) {
+	st, port := newFake()
+	for _, count := range []int16{2048, -2048, 32767, -32768} {
+		if err := st.MotorRaw(1, count); err == nil {
+			t.Errorf("count %d accepted", count)
+		}
+	}
+	expectWrites(t, port)
+}
+
+func TestSetLogger(t *testing.T) {
+	st, port := newFake()
+	port.queue(reply(128, CmdGetBattery, 245, 'M', 1))
+	var buf bytes.Buffer
+	st.SetLogger(log.New(&buf, "", 0))
+	_, err := st.Battery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("sabertooth 128: tx [% x]\nsabertooth 128: rx [% x]\n",
+		getCommand(128, CmdGetBattery, 'M', 1), reply(128, CmdGetBattery, 245, 'M', 1))
+	if buf.String() != want {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+	buf.Reset()
+	st.SetLogger(nil)
+	err = st.Motor(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("logged %q after the logger was removed", buf.String())
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	st, _ := newFake(WithLogger(log.New(&buf, "", 0)))
+	err := st.Motor(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("sabertooth 128: tx [% x]\n", setCommand(128, CmdSetValue, 'M', 1, 1024))
+	if buf.String() != want {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+}
+
+func TestNameInLog(t *testing.T) {
+	var buf bytes.Buffer
+	st, _ := newFake(WithLogger(log.New(&buf, "", 0)))
+	st.SetName("left_drive")
+	if got, want := st.String(), "left_drive (sabertooth 128)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	err := st.Motor(1, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("left_drive (sabertooth 128): tx [% x]\n", setCommand(128, CmdSetValue, 'M', 1, 1024))
+	if buf.String() != want {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+}
+
+func TestNameWithPort(t *testing.T) {
+	_, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(129, "/dev/ttyUSB0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.String(), "sabertooth 129 on /dev/ttyUSB0"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	st.SetName("right_drive")
+	if got, want := st.String(), "right_drive (sabertooth 129 on /dev/ttyUSB0)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := st.Name(); got != "right_drive" {
+		t.Errorf("Name() = %q, want %q", got, "right_drive")
+	}
+}
+
+func TestReplyDuringWriteIsKept(t *testing.T) {
+	// The fake port buffers the reply while the command is written, as a
+	// fast device on a half-duplex link does, and returns it a byte at a
+	// time
+	st, port := newFake(WithRetries(1))
+	port.chunk = 1
+	port.queue(reply(128, CmdGetCurrent, -12, 'M', 2))
+	value, err := st.Read(CmdGetCurrent, 'M', 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != -12 {
+		t.Errorf("value %d, want -12", value)
+	}
+	if port.resets != 0 {
+		t.Errorf("input flushed %d times between the write and the read", port.resets)
+	}
+	if port.reads != ReplyLength {
+		t.Errorf("%d reads, want %d", port.reads, ReplyLength)
+	}
+}
+
+// fakePorts makes the serial port listings return ports. It returns a
+// function restoring the real listings.
+func fakePorts(ports ...*enumerator.PortDetails) func() {
+	origList, origDetailed := portsList, detailedPortsList
+	portsList = func() ([]string, error) {
+		var names []string
+		for _, p := range ports {
+			names = append(names, p.Name)
+		}
+		return names, nil
+	}
+	detailedPortsList = func() ([]*enumerator.PortDetails, error) {
+		return ports, nil
+	}
+	return func() {
+		portsList, detailedPortsList = origList, origDetailed
+	}
+}
+
+var (
+	otherPort      = &enumerator.PortDetails{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001"}
+	sabertoothPort = &enumerator.PortDetails{Name: "/dev/ttyACM0", IsUSB: true, VID: "268B", PID: "0201", Product: "Sabertooth 2x32"}
+)
+
+func TestDiscover(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	defer fakePorts(otherPort, sabertoothPort)()
+	// Answer the ping of Discover on the port as it is opened
+	openSerial = func(name string, mode *serial.Mode) (serial.Port, error) {
+		if name != sabertoothPort.Name {
+			t.Errorf("opened %s, want %s", name, sabertoothPort.Name)
+		}
+		port := &fakePort{mode: *mode, respond: telemetryDevice}
+		*opened = append(*opened, port)
+		return port, nil
+	}
+	st, err := Discover(129)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.String(), "sabertooth 129 on /dev/ttyACM0"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	expectWrites(t, (*opened)[0], getCommand(129, CmdGetBattery, 'M', 1))
+}
+
+func TestDiscoverNotFound(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	defer fakePorts(otherPort)()
+	_, err := Discover(128)
+	if err == nil || !strings.HasPrefix(err.Error(), "find port: ") {
+		t.Errorf("got %v, want a find port error", err)
+	}
+	if len(*opened) != 0 {
+		t.Errorf("%d ports opened", len(*opened))
+	}
+}
+
+func TestDiscoverNoReply(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	defer fakePorts(sabertoothPort)()
+	_, err := Discover(128)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a TimeoutError", err)
+	}
+	if !strings.HasPrefix(err.Error(), "ping address 128 on /dev/ttyACM0: ") {
+		t.Errorf("error %q does not tell the failed step", err)
+	}
+	if !(*opened)[0].closed {
+		t.Error("port left open")
+	}
+}
+
+func TestSafeGuardStopsOnPanic(t *testing.T) {
+	st, port := newFake()
+	func() {
+		defer func() {
+			if r := recover(); r != "control loop failed" {
+				t.Errorf("recovered %v, want the panic to continue", r)
+			}
+		}()
+		defer st.SafeGuard()()
+		err := st.Motor(1, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		panic("control loop failed")
+	}()
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 1, 1024),
+		setCommand(128, CmdSetValue, 'M', 1, 0),
+		setCommand(128, CmdSetValue, 'M', 2, 0),
+	)
+	if speed := st.LastSpeed(1); speed != 0 {
+		t.Errorf("last speed %v, want 0", speed)
+	}
+}
+
+func TestSafeGuardLogsStopFailure(t *testing.T) {
+	var buf bytes.Buffer
+	st, port := newFake(WithLogger(log.New(&buf, "", 0)))
+	port.writeErr = errors.New("input/output error")
+	st.SafeGuard()()
+	want := "sabertooth 128: safeguard stop: write: input/output error\n"
+	if !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	st, port := newFake()
+	cmd := getCommand(128, CmdGetBattery, 'M', 1)
+	r := reply(128, CmdGetBattery, 245, 'M', 1)
+	port.queue(r)
+	data, err := st.Transaction(cmd, len(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, r) {
+		t.Errorf("got [% x], want [% x]", data, r)
+	}
+	data, err = st.Transaction(setCommand(128, CmdSetValue, 'M', 1, 0), 0)
+	if err != nil || data != nil {
+		t.Errorf("got [% x], %v for no reply", data, err)
+	}
+	if _, err := st.Transaction(cmd, -1); err == nil {
+		t.Error("negative reply length accepted")
+	}
+	expectWrites(t, port, cmd, setCommand(128, CmdSetValue, 'M', 1, 0))
+}
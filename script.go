@@ -0,0 +1,169 @@
+package sabertooth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ScriptStep is a step of a motion script run by RunScript. It sets the
+// speed of a motor and then waits for Duration before the next step.
+type ScriptStep struct {
+	Motor    int
+	Speed    float64
+	Duration time.Duration
+}
+
+// RunScript runs a sequence of motor commands with delays in between, for
+// example for bench tests or demos. The motors are stopped when the script
+// ends, fails or ctx is done. If ctx is done before the script ends its
+// error is returned.
+func (st *Sabertooth) RunScript(ctx context.Context, steps []ScriptStep) (err error) {
+	defer func() {
+		stopErr := st.Stop()
+		if err == nil {
+			err = stopErr
+		}
+	}()
+	latency, err := st.latency()
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		err = st.Motor(step.Motor, step.Speed)
+		if err != nil {
+			return err
+		}
+		err = sleep(ctx, compensate(step.Duration, latency))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latency returns the round trip time to subtract from the waits of
+// scripts and ramps when latency compensation is enabled, and 0 otherwise
+func (st *Sabertooth) latency() (time.Duration, error) {
+	st.mu.Lock()
+	enabled := st.compensateLatency
+	st.mu.Unlock()
+	if !enabled {
+		return 0, nil
+	}
+	return st.RoundTripTime()
+}
+
+// compensate returns d shortened by latency, but not below 0
+func compensate(d, latency time.Duration) time.Duration {
+	if d < latency {
+		return 0
+	}
+	return d - latency
+}
+
+// sleep waits for d or until ctx is done, in which case ctx.Err() is
+// returned. Tests replace it to record the waits.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rampInterval is the time between the speed updates of a ramp
+const rampInterval = 20 * time.Millisecond
+
+// StopRamped ramps both motors linearly from their last speed down to 0
+// over duration, and then stops them. If ctx is done or a command fails
+// during the ramp, the motors are stopped immediately.
+func (st *Sabertooth) StopRamped(ctx context.Context, duration time.Duration) (err error) {
+	defer func() {
+		stopErr := st.Stop()
+		if err == nil {
+			err = stopErr
+		}
+	}()
+	latency, err := st.latency()
+	if err != nil {
+		return err
+	}
+	start := []float64{st.LastSpeed(1), st.LastSpeed(2)}
+	steps := int(duration / rampInterval)
+	for i := 1; i < steps; i++ {
+		err = sleep(ctx, compensate(rampInterval, latency))
+		if err != nil {
+			return err
+		}
+		remaining := 1 - float64(i)/float64(steps)
+		for motor := 1; motor <= 2; motor++ {
+			if start[motor-1] == 0 {
+				continue
+			}
+			err = st.Motor(motor, start[motor-1]*remaining)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return sleep(ctx, compensate(rampInterval, latency))
+}
+
+// MotorSCurve moves a motor from its last speed to target over duration
+// along an S-curve, which starts and ends with zero acceleration for
+// smoother motion than a linear ramp. If ctx is done or a command fails
+// the motor is left at the speed reached and the error is returned.
+func (st *Sabertooth) MotorSCurve(ctx context.Context, motor int, target float64, duration time.Duration) error {
+	if target < -1 || target > 1 {
+		return errors.New("value out of range")
+	}
+	latency, err := st.latency()
+	if err != nil {
+		return err
+	}
+	start := st.LastSpeed(motor)
+	steps := int(duration / rampInterval)
+	for i := 1; i < steps; i++ {
+		err = sleep(ctx, compensate(rampInterval, latency))
+		if err != nil {
+			return err
+		}
+		err = st.Motor(motor, start+(target-start)*sCurve(float64(i)/float64(steps)))
+		if err != nil {
+			return err
+		}
+	}
+	err = sleep(ctx, compensate(rampInterval, latency))
+	if err != nil {
+		return err
+	}
+	return st.Motor(motor, target)
+}
+
+// sCurve maps t from 0 to 1 onto an S-curve from 0 to 1 with zero slope
+// and curvature at both ends
+func sCurve(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+// ShutdownSequence shuts the device down safely: it ramps the motors to a
+// stop over rampDuration with StopRamped, disables all outputs with
+// Disable and closes the port. Each step is run even if an earlier step
+// failed, and the first error is returned.
+func (st *Sabertooth) ShutdownSequence(ctx context.Context, rampDuration time.Duration) error {
+	errs := []error{
+		st.StopRamped(ctx, rampDuration),
+		st.Disable(),
+		st.Close(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
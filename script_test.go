@@ -0,0 +1,251 @@
+package sabertooth
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunScript(t *testing.T) {
+	st, port := newFake()
+	steps := []ScriptStep{
+		{1, 0.5, 10 * time.Millisecond},
+		{2, -0.5, 10 * time.Millisecond},
+		{1, 0, 0},
+	}
+	start := time.Now()
+	err := st.RunScript(context.Background(), steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("script took %v, want at least 20ms", elapsed)
+	}
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 1, 1024),
+		setCommand(128, CmdSetValue, 'M', 2, -1024),
+		setCommand(128, CmdSetValue, 'M', 1, 0),
+		setCommand(128, CmdSetValue, 'M', 1, 0),
+		setCommand(128, CmdSetValue, 'M', 2, 0),
+	)
+}
+
+func TestRunScriptCancel(t *testing.T) {
+	st, port := newFake()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := st.RunScript(ctx, []ScriptStep{
+		{1, 1, time.Second},
+		{2, 1, time.Second},
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 1, 2047),
+		setCommand(128, CmdSetValue, 'M', 1, 0),
+		setCommand(128, CmdSetValue, 'M', 2, 0),
+	)
+}
+
+func TestShutdownSequence(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(128, "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Motor(1, 0.6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.ShutdownSequence(context.Background(), 3*rampInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := (*opened)[0]
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 1, Denormalize(0.6)),
+		setCommand(128, CmdSetValue, 'M', 1, Denormalize(0.4)),
+		setCommand(128, CmdSetValue, 'M', 1, Denormalize(0.2)),
+		setCommand(128, CmdSetValue, 'M', 1, 0),
+		setCommand(128, CmdSetValue, 'M', 2, 0),
+		setCommand(128, CmdSetShutdown, 'M', 1, 2048),
+		setCommand(128, CmdSetShutdown, 'M', 2, 2048),
+		setCommand(128, CmdSetShutdown, 'P', 1, 2048),
+		setCommand(128, CmdSetShutdown, 'P', 2, 2048),
+	)
+	if !port.closed {
+		t.Error("port left open")
+	}
+	if st.Enabled() {
+		t.Error("outputs left enabled")
+	}
+}
+
+func TestShutdownSequenceContinuesAfterError(t *testing.T) {
+	opened, restore := fakeOpen()
+	defer restore()
+	st, err := NewSabertooth(128, "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Motor(1, 0.6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = st.ShutdownSequence(ctx, time.Second)
+	if err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	port := (*opened)[0]
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 1, Denormalize(0.6)),
+		setCommand(128, CmdSetValue, 'M', 1, 0),
+		setCommand(128, CmdSetValue, 'M', 2, 0),
+		setCommand(128, CmdSetShutdown, 'M', 1, 2048),
+		setCommand(128, CmdSetShutdown, 'M', 2, 2048),
+		setCommand(128, CmdSetShutdown, 'P', 1, 2048),
+		setCommand(128, CmdSetShutdown, 'P', 2, 2048),
+	)
+	if !port.closed {
+		t.Error("port left open")
+	}
+}
+
+func TestSCurve(t *testing.T) {
+	for _, p := range [][2]float64{{0, 0}, {0.5, 0.5}, {1, 1}} {
+		if got := sCurve(p[0]); got != p[1] {
+			t.Errorf("sCurve(%v) = %v, want %v", p[0], got, p[1])
+		}
+	}
+	last := 0.0
+	for i := 1; i <= 100; i++ {
+		x := float64(i) / 100
+		y := sCurve(x)
+		if y < last {
+			t.Errorf("sCurve decreases at %v", x)
+		}
+		if d := y + sCurve(1-x) - 1; d > 1e-12 || d < -1e-12 {
+			t.Errorf("sCurve is not symmetric at %v", x)
+		}
+		last = y
+	}
+}
+
+func TestMotorSCurve(t *testing.T) {
+	st, port := newFake()
+	start := time.Now()
+	err := st.MotorSCurve(context.Background(), 1, 1, 5*rampInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 5*rampInterval {
+		t.Errorf("S-curve took %v, want at least %v", elapsed, 5*rampInterval)
+	}
+	want := []int16{
+		Denormalize(sCurve(0.2)),
+		Denormalize(sCurve(0.4)),
+		Denormalize(sCurve(0.6)),
+		Denormalize(sCurve(0.8)),
+		2047,
+	}
+	var frames [][]byte
+	for _, v := range want {
+		frames = append(frames, setCommand(128, CmdSetValue, 'M', 1, v))
+	}
+	expectWrites(t, port, frames...)
+	// Slow at both ends, fastest in the middle
+	if !(want[0] < want[2]-want[1] && want[4]-want[3] < want[2]-want[1]) {
+		t.Errorf("trajectory %v is not an S-curve", want)
+	}
+}
+
+func TestMotorSCurveCancel(t *testing.T) {
+	st, port := newFake()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*rampInterval-rampInterval/2)
+	defer cancel()
+	err := st.MotorSCurve(ctx, 2, -1, 10*rampInterval)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 2, Denormalize(-sCurve(0.1))),
+		setCommand(128, CmdSetValue, 'M', 2, Denormalize(-sCurve(0.2))),
+	)
+	if speed, want := st.LastSpeed(2), -sCurve(0.2); speed != want {
+		t.Errorf("left at %v, want %v", speed, want)
+	}
+}
+
+func TestCompensate(t *testing.T) {
+	tests := []struct {
+		d, latency, want time.Duration
+	}{
+		{50 * time.Millisecond, 0, 50 * time.Millisecond},
+		{50 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond},
+		{50 * time.Millisecond, 50 * time.Millisecond, 0},
+		{50 * time.Millisecond, 80 * time.Millisecond, 0},
+	}
+	for _, test := range tests {
+		if got := compensate(test.d, test.latency); got != test.want {
+			t.Errorf("compensate(%v, %v) = %v, want %v", test.d, test.latency, got, test.want)
+		}
+	}
+}
+
+// recordSleeps replaces sleep with one that returns at once and appends
+// the requested waits to waits. Call restore when done.
+func recordSleeps(waits *[]time.Duration) (restore func()) {
+	saved := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return ctx.Err()
+	}
+	return func() { sleep = saved }
+}
+
+func TestRunScriptLatencyCompensation(t *testing.T) {
+	const delay, step = 25 * time.Millisecond, 50 * time.Millisecond
+	steps := []ScriptStep{{1, 0.5, step}, {1, -0.5, step}, {2, 0.5, step}, {2, -0.5, step}}
+	for _, compensate := range []bool{false, true} {
+		var waits []time.Duration
+		restore := recordSleeps(&waits)
+		st, port := newFake(WithLatencyCompensation(compensate))
+		port.respond = telemetryDevice
+		port.delay = delay
+		err := st.RunScript(context.Background(), steps)
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(waits) != len(steps) {
+			t.Fatalf("compensate %v: %d waits, want %d", compensate, len(waits), len(steps))
+		}
+		// The write delay is part of the round trip time, so compensated
+		// waits leave at least that much of each step to the write
+		for i, wait := range waits {
+			if !compensate && wait != step {
+				t.Errorf("wait %d: %v, want %v", i, wait, step)
+			}
+			if compensate && (wait < 0 || wait > step-delay) {
+				t.Errorf("compensated wait %d: %v, want between 0 and %v", i, wait, step-delay)
+			}
+		}
+		writes := port.written()
+		// A frame per step and the stop of both motors
+		want := len(steps) + 2
+		if compensate {
+			if m := getCommand(128, CmdGetBattery, 'M', 1); !bytes.Equal(writes[0], m) {
+				t.Errorf("first write [% x], want the round trip measurement [% x]", writes[0], m)
+			}
+			want++
+		}
+		if len(writes) != want {
+			t.Errorf("compensate %v: %d writes, want %d", compensate, len(writes), want)
+		}
+	}
+}
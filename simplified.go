@@ -0,0 +1,43 @@
+package sabertooth
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// In simplified serial mode each command is a single byte without address
+// or checksum. Bytes 1 to 127 control motor 1, from full reverse through
+// stop at 64 to full forward. Bytes 128 to 255 control motor 2, from full
+// reverse through stop at 192 to full forward. Byte 0 stops both motors.
+const (
+	simplifiedStopAll = 0
+	simplifiedStop1   = 64
+	simplifiedStop2   = 192
+)
+
+// simplifiedCommand encodes the speed of a motor, between -1 and 1, as a
+// simplified serial command
+func simplifiedCommand(motor int, speed float64) (byte, error) {
+	switch motor {
+	case 1:
+		return byte(simplifiedStop1 + math.Round(speed*63)), nil
+	case 2:
+		if speed < 0 {
+			return byte(simplifiedStop2 + math.Round(speed*64)), nil
+		}
+		return byte(simplifiedStop2 + math.Round(speed*63)), nil
+	}
+	return 0, errors.New("invalid motor")
+}
+
+// sendSimplified sends a simplified serial command. The caller must hold
+// st.mu.
+func (st *Sabertooth) sendSimplified(cmd byte) error {
+	_, err := st.transaction([]byte{cmd}, 0)
+	if err != nil {
+		return err
+	}
+	st.lastCommand = time.Now()
+	return nil
+}
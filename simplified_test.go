@@ -0,0 +1,55 @@
+package sabertooth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSimplifiedCommand(t *testing.T) {
+	tests := []struct {
+		motor int
+		speed float64
+		want  byte
+	}{
+		{1, -1, 1},
+		{1, -0.5, 32},
+		{1, 0, 64},
+		{1, 0.5, 96},
+		{1, 1, 127},
+		{2, -1, 128},
+		{2, -0.5, 160},
+		{2, 0, 192},
+		{2, 0.5, 224},
+		{2, 1, 255},
+	}
+	for _, test := range tests {
+		got, err := simplifiedCommand(test.motor, test.speed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("motor %d speed %v: got %d, want %d", test.motor, test.speed, got, test.want)
+		}
+	}
+	if _, err := simplifiedCommand(3, 0); err == nil {
+		t.Error("motor 3 accepted")
+	}
+}
+
+func TestSimplifiedSerialMotor(t *testing.T) {
+	st, port := newFake(WithSimplifiedSerial(true))
+	for _, call := range []func() error{
+		func() error { return st.Motor(1, 1) },
+		func() error { return st.Motor(2, -1) },
+		func() error { return st.Stop() },
+	} {
+		err := call()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectWrites(t, port, []byte{127}, []byte{128}, []byte{0})
+	if _, err := st.Battery(); !errors.Is(err, ErrSimplifiedSerial) {
+		t.Errorf("Battery: got %v, want ErrSimplifiedSerial", err)
+	}
+}
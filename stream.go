@@ -0,0 +1,54 @@
+package sabertooth
+
+import "io"
+
+// RawStream returns an io.ReadWriter reading and writing the raw byte
+// stream of the serial port, for example to record or replay a session or
+// to bridge the port over a network. Each Read and Write holds the lock of
+// the Sabertooth, so they never interleave with a command, but a command
+// can run between two calls. A reply read with RawStream is lost to the
+// command waiting for it, and a frame written in several Writes can be
+// interrupted by a command, so mixing RawStream with the other methods
+// needs care. A Read returns a *TimeoutError if no data arrives within the
+// read timeout. RawStream can not be used with WithTransientPort.
+func (st *Sabertooth) RawStream() io.ReadWriter {
+	return rawStream{st}
+}
+
+type rawStream struct {
+	st *Sabertooth
+}
+
+func (r rawStream) Read(p []byte) (int, error) {
+	st := r.st
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := st.acquirePort()
+	if err != nil {
+		return 0, err
+	}
+	err = st.port.SetReadTimeout(st.readTimeout)
+	if err != nil {
+		return 0, err
+	}
+	n, err := st.port.Read(p)
+	if n == 0 && err == nil {
+		return 0, &TimeoutError{Expected: len(p)}
+	}
+	return n, err
+}
+
+func (r rawStream) Write(p []byte) (int, error) {
+	st := r.st
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := st.acquirePort()
+	if err != nil {
+		return 0, err
+	}
+	err = st.write(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
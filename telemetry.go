@@ -0,0 +1,278 @@
+package sabertooth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ReadAll reads all telemetry values of the device and returns them by
+// name: "battery" in volts, "current1" and "current2" in ampere and
+// "temp1" and "temp2" in degrees Celsius. Values the device does not reply
+// to, or replies to with an invalid reply, and values the model does not
+// have, see WithModel, are left out of the map. If the
+// port fails ReadAll stops and returns the values read so far together
+// with the error.
+func (st *Sabertooth) ReadAll() (map[string]float64, error) {
+	reads := []struct {
+		name string
+		read func() (float64, error)
+	}{
+		{"battery", st.Battery},
+		{"current1", func() (float64, error) { return st.Current(1) }},
+		{"current2", func() (float64, error) { return st.Current(2) }},
+		{"temp1", func() (float64, error) {
+			temp, err := st.Temp(1)
+			return float64(temp), err
+		}},
+		{"temp2", func() (float64, error) {
+			temp, err := st.Temp(2)
+			return float64(temp), err
+		}},
+	}
+	values := make(map[string]float64)
+	for _, r := range reads {
+		value, err := r.read()
+		var protocolErr *ProtocolError
+		if errors.As(err, &protocolErr) || errors.Is(err, ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return values, err
+		}
+		values[r.name] = value
+	}
+	return values, nil
+}
+
+// StreamCurrent reads the current of a motor every interval and sends the
+// readings, in ampere, on the returned channel until ctx is done, after
+// which the channel is closed. Failed readings are dropped rather than
+// repeating an old value, so a gap in the stream means the reading failed.
+// A reading is also dropped if the receiver is not ready for it. interval
+// must be greater than 0.
+func (st *Sabertooth) StreamCurrent(ctx context.Context, motor int, interval time.Duration) (<-chan float64, error) {
+	if interval <= 0 {
+		return nil, errors.New("invalid interval")
+	}
+	c := make(chan float64, 1)
+	go func() {
+		defer close(c)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			current, err := st.Current(motor)
+			if err != nil {
+				continue
+			}
+			select {
+			case c <- current:
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+	}()
+	return c, nil
+}
+
+// MetricsSnapshot holds the telemetry of a device for export to a
+// monitoring system. The field tags give stable metric names, for example
+// for Prometheus gauges.
+type MetricsSnapshot struct {
+	BatteryVolts float64 `json:"battery_volts"`
+	Motor1Amps   float64 `json:"motor1_amps"`
+	Motor2Amps   float64 `json:"motor2_amps"`
+	Motor1TempC  float64 `json:"motor1_temp_c"`
+	Motor2TempC  float64 `json:"motor2_temp_c"`
+}
+
+// MetricsSnapshot reads all telemetry of the device. An error is returned
+// if any of the values can not be read. Values the model does not have,
+// see WithModel, are exported as 0, so a single sensor model always
+// reports a Motor2TempC of 0.
+func (st *Sabertooth) MetricsSnapshot() (MetricsSnapshot, error) {
+	t, err := st.ReadTelemetry()
+	if err != nil {
+		return MetricsSnapshot{}, err
+	}
+	return MetricsSnapshot{
+		BatteryVolts: t.Battery.Value,
+		Motor1Amps:   t.Current1.Value,
+		Motor2Amps:   t.Current2.Value,
+		Motor1TempC:  t.Temp1.Value,
+		Motor2TempC:  t.Temp2.Value,
+	}, nil
+}
+
+// Reading is a telemetry value together with the time it was read
+type Reading struct {
+	Value     float64
+	SampledAt time.Time
+}
+
+// Telemetry holds the telemetry of a device: the battery voltage in
+// volts, the motor currents in ampere and the temperatures in degrees
+// Celsius
+type Telemetry struct {
+	Battery  Reading
+	Current1 Reading
+	Current2 Reading
+	Temp1    Reading
+	Temp2    Reading
+}
+
+// ReadTelemetry reads all telemetry of the device. Each value is
+// timestamped when its reply is received, so that values read around
+// other commands can be told apart by age. An error is returned if any of
+// the values can not be read. Values the model does not have, see
+// WithModel, are left zero, with a zero SampledAt.
+func (st *Sabertooth) ReadTelemetry() (Telemetry, error) {
+	var t Telemetry
+	reads := []struct {
+		reading *Reading
+		read    func() (float64, error)
+	}{
+		{&t.Battery, st.Battery},
+		{&t.Current1, func() (float64, error) { return st.Current(1) }},
+		{&t.Current2, func() (float64, error) { return st.Current(2) }},
+		{&t.Temp1, func() (float64, error) {
+			temp, err := st.Temp(1)
+			return float64(temp), err
+		}},
+		{&t.Temp2, func() (float64, error) {
+			temp, err := st.Temp(2)
+			return float64(temp), err
+		}},
+	}
+	for _, r := range reads {
+		value, err := r.read()
+		if errors.Is(err, ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return t, err
+		}
+		*r.reading = Reading{value, time.Now()}
+	}
+	st.mu.Lock()
+	st.extremes.update(t)
+	st.mu.Unlock()
+	return t, nil
+}
+
+// MinMax is the lowest and highest value seen of a telemetry value
+type MinMax struct {
+	Min float64
+	Max float64
+}
+
+// update extends m to include value. first is true for the first value.
+func (m *MinMax) update(value float64, first bool) {
+	if first || value < m.Min {
+		m.Min = value
+	}
+	if first || value > m.Max {
+		m.Max = value
+	}
+}
+
+// TelemetryExtremes holds the lowest and highest telemetry values read with
+// ReadTelemetry, for example the peak current and the lowest battery
+// voltage during a run. Samples is the number of times the telemetry was
+// read; the values are only valid when it is above 0. Values the model
+// does not have, see WithModel, stay 0.
+type TelemetryExtremes struct {
+	Samples  int
+	Battery  MinMax
+	Current1 MinMax
+	Current2 MinMax
+	Temp1    MinMax
+	Temp2    MinMax
+}
+
+// update extends e with the values of t. Values that were not read, see
+// ReadTelemetry, are skipped.
+func (e *TelemetryExtremes) update(t Telemetry) {
+	first := e.Samples == 0
+	for _, v := range []struct {
+		m *MinMax
+		r Reading
+	}{
+		{&e.Battery, t.Battery},
+		{&e.Current1, t.Current1},
+		{&e.Current2, t.Current2},
+		{&e.Temp1, t.Temp1},
+		{&e.Temp2, t.Temp2},
+	} {
+		if !v.r.SampledAt.IsZero() {
+			v.m.update(v.r.Value, first)
+		}
+	}
+	e.Samples++
+}
+
+// TelemetryExtremes returns the lowest and highest telemetry values read
+// with ReadTelemetry, or MetricsSnapshot, since the device was created or
+// ResetExtremes was called
+func (st *Sabertooth) TelemetryExtremes() TelemetryExtremes {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.extremes
+}
+
+// ResetExtremes clears the values returned by TelemetryExtremes
+func (st *Sabertooth) ResetExtremes() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.extremes = TelemetryExtremes{}
+}
+
+// batteryStableInterval is the time between the readings of BatteryStable
+const batteryStableInterval = 50 * time.Millisecond
+
+// BatteryStable reads the battery voltage repeatedly until the last
+// samples readings are all within tolerance volts of each other, and
+// returns their average. It gives a trustworthy voltage at start up, when
+// inrush current makes single readings unreliable. It returns ctx.Err() if
+// ctx is done before the voltage is stable.
+func (st *Sabertooth) BatteryStable(ctx context.Context, tolerance float64, samples int) (float64, error) {
+	if samples < 1 {
+		return 0, errors.New("invalid number of samples")
+	}
+	readings := make([]float64, 0, samples)
+	for {
+		bat, err := st.Battery()
+		if err != nil {
+			return 0, err
+		}
+		if len(readings) == samples {
+			readings = append(readings[:0], readings[1:]...)
+		}
+		readings = append(readings, bat)
+		if len(readings) == samples {
+			min, max, sum := readings[0], readings[0], 0.0
+			for _, r := range readings {
+				if r < min {
+					min = r
+				}
+				if r > max {
+					max = r
+				}
+				sum += r
+			}
+			if max-min <= tolerance {
+				return sum / float64(samples), nil
+			}
+		}
+		err = sleep(ctx, batteryStableInterval)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
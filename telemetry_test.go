@@ -0,0 +1,256 @@
+package sabertooth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// telemetryDevice answers the telemetry Gets with battery 24.5 V, currents
+// of 3.5 A and -1.2 A and temperatures of 31 and 33 degrees
+func telemetryDevice(cmd []byte) []byte {
+	getType, number := cmd[2], cmd[5]
+	values := map[byte][2]int{
+		CmdGetBattery: {245, 245},
+		CmdGetCurrent: {35, -12},
+		CmdGetTemp:    {31, 33},
+	}
+	return reply(cmd[0], getType, values[getType][number-1], cmd[4], number)
+}
+
+func TestMetricsSnapshot(t *testing.T) {
+	st, port := newFake()
+	port.respond = telemetryDevice
+	m, err := st.MetricsSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MetricsSnapshot{24.5, 3.5, -1.2, 31, 33}
+	if m != want {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON := `{"battery_volts":24.5,"motor1_amps":3.5,"motor2_amps":-1.2,"motor1_temp_c":31,"motor2_temp_c":33}`
+	if string(data) != wantJSON {
+		t.Errorf("got %s, want %s", data, wantJSON)
+	}
+}
+
+// batterySequence answers battery Gets with the raw readings in turn,
+// repeating the last one
+func batterySequence(readings ...int) func(cmd []byte) []byte {
+	i := 0
+	return func(cmd []byte) []byte {
+		r := readings[i]
+		if i < len(readings)-1 {
+			i++
+		}
+		return reply(cmd[0], cmd[2], r, cmd[4], cmd[5])
+	}
+}
+
+func TestBatteryStable(t *testing.T) {
+	st, port := newFake()
+	port.respond = batterySequence(240, 250, 245, 245, 246)
+	got, err := st.BatteryStable(context.Background(), 0.15, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (24.5 + 24.5 + 24.6) / 3; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if n := len(port.written()); n != 5 {
+		t.Errorf("%d readings, want 5", n)
+	}
+}
+
+func TestBatteryStableCanceled(t *testing.T) {
+	st, port := newFake()
+	port.respond = batterySequence(240, 250, 240, 250, 240, 250, 240, 250, 240, 250)
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	_, err := st.BatteryStable(ctx, 0.5, 2)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBatteryStableInvalidSamples(t *testing.T) {
+	st, port := newFake()
+	if _, err := st.BatteryStable(context.Background(), 0.1, 0); err == nil {
+		t.Error("0 samples accepted")
+	}
+	expectWrites(t, port)
+}
+
+func TestReadTelemetrySampledAt(t *testing.T) {
+	st, port := newFake()
+	port.respond = telemetryDevice
+	before := time.Now()
+	tel, err := st.ReadTelemetry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+	readings := []Reading{tel.Battery, tel.Current1, tel.Current2, tel.Temp1, tel.Temp2}
+	last := before
+	for i, r := range readings {
+		if r.SampledAt.Before(last) || r.SampledAt.After(after) {
+			t.Errorf("reading %d sampled at %v, want between %v and %v", i, r.SampledAt, last, after)
+		}
+		last = r.SampledAt
+	}
+	want := []float64{24.5, 3.5, -1.2, 31, 33}
+	for i, r := range readings {
+		if r.Value != want[i] {
+			t.Errorf("reading %d is %v, want %v", i, r.Value, want[i])
+		}
+	}
+}
+
+func TestReadTelemetryFails(t *testing.T) {
+	st, port := newFake()
+	port.queue(reply(128, CmdGetBattery, 245, 'M', 1))
+	tel, err := st.ReadTelemetry()
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a TimeoutError", err)
+	}
+	if tel.Battery.Value != 24.5 || tel.Battery.SampledAt.IsZero() {
+		t.Errorf("battery reading %+v lost", tel.Battery)
+	}
+	if !tel.Current1.SampledAt.IsZero() {
+		t.Errorf("failed reading sampled at %v", tel.Current1.SampledAt)
+	}
+}
+
+func TestTelemetryExtremes(t *testing.T) {
+	st, port := newFake()
+	if e := st.TelemetryExtremes(); e.Samples != 0 {
+		t.Fatalf("%d samples before any reading", e.Samples)
+	}
+	samples := []map[byte][2]int{
+		{CmdGetBattery: {245, 245}, CmdGetCurrent: {35, -12}, CmdGetTemp: {31, 33}},
+		{CmdGetBattery: {231, 231}, CmdGetCurrent: {120, 4}, CmdGetTemp: {35, 32}},
+		{CmdGetBattery: {238, 238}, CmdGetCurrent: {-50, -30}, CmdGetTemp: {34, 36}},
+	}
+	var sample map[byte][2]int
+	port.respond = func(cmd []byte) []byte {
+		getType, number := cmd[2], cmd[5]
+		return reply(cmd[0], getType, sample[getType][number-1], cmd[4], number)
+	}
+	for _, sample = range samples {
+		_, err := st.ReadTelemetry()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := TelemetryExtremes{
+		Samples:  3,
+		Battery:  MinMax{23.1, 24.5},
+		Current1: MinMax{-5, 12},
+		Current2: MinMax{-3, 0.4},
+		Temp1:    MinMax{31, 35},
+		Temp2:    MinMax{32, 36},
+	}
+	if e := st.TelemetryExtremes(); e != want {
+		t.Errorf("got %+v, want %+v", e, want)
+	}
+	st.ResetExtremes()
+	if e := st.TelemetryExtremes(); e != (TelemetryExtremes{}) {
+		t.Errorf("got %+v after ResetExtremes", e)
+	}
+}
+
+func TestTempSensors(t *testing.T) {
+	st, port := newFake(WithModel("Sabertooth 2x32", 2))
+	port.respond = telemetryDevice
+	temp, err := st.Temp(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp != 33 {
+		t.Errorf("temp %d, want 33", temp)
+	}
+
+	st, port = newFake(WithModel("Sabertooth 2x12", 1))
+	port.respond = telemetryDevice
+	if _, err := st.Temp(2); err != ErrUnsupported {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+	expectWrites(t, port)
+	if model, err := st.Model(); err != nil || model != "Sabertooth 2x12" {
+		t.Errorf("model %q, %v, want Sabertooth 2x12", model, err)
+	}
+}
+
+func TestTelemetrySingleSensor(t *testing.T) {
+	st, port := newFake(WithModel("Sabertooth 2x12", 1))
+	port.respond = telemetryDevice
+	values, err := st.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"battery": 24.5, "current1": 3.5, "current2": -1.2, "temp1": 31}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("ReadAll got %v, want %v", values, want)
+	}
+	tel, err := st.ReadTelemetry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tel.Temp2 != (Reading{}) {
+		t.Errorf("unsupported reading %+v, want zero", tel.Temp2)
+	}
+	m, err := st.MetricsSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Motor2TempC != 0 {
+		t.Errorf("unsupported Motor2TempC %v, want 0", m.Motor2TempC)
+	}
+	// The zero readings do not drag down the minimum
+	e := st.TelemetryExtremes()
+	if e.Samples != 2 || e.Temp1 != (MinMax{31, 31}) || e.Temp2 != (MinMax{}) {
+		t.Errorf("extremes %+v", e)
+	}
+	for _, w := range port.written() {
+		if w[2] == CmdGetTemp && w[5] == 2 {
+			t.Errorf("unsupported sensor read with [% x]", w)
+		}
+	}
+}
+
+func TestStreamCurrent(t *testing.T) {
+	st, port := newFake()
+	port.respond = telemetryDevice
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := st.StreamCurrent(ctx, 2, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if current := <-c; current != -1.2 {
+			t.Errorf("current %v, want -1.2", current)
+		}
+	}
+	cancel()
+	for range c {
+	}
+}
+
+func TestStreamCurrentInvalidInterval(t *testing.T) {
+	st, _ := newFake()
+	for _, interval := range []time.Duration{-time.Second, 0} {
+		if _, err := st.StreamCurrent(context.Background(), 1, interval); err == nil {
+			t.Errorf("interval %v accepted", interval)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package sabertooth
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// WithTransport makes the device use rw instead of a serial port, for
+// example a net.Conn to a serial over TCP bridge. OpenPort and Close do
+// not open or close rw, as with NewSabertoothWithPort, and the port name
+// given to NewSabertooth is not used.
+//
+// Read timeouts are applied with SetReadDeadline if rw has it, as net.Conn
+// does. Otherwise reads block until data arrives. To encode commands
+// without a device, for example on the far side of a bridge, use
+// AppendSetCommand and AppendGetCommand.
+func WithTransport(rw io.ReadWriter) Option {
+	return func(st *Sabertooth) {
+		st.port = &transportPort{rw: rw}
+		st.externalPort = true
+	}
+}
+
+// transportPort adapts an io.ReadWriter to serial.Port. The serial line
+// settings do not apply and are ignored.
+type transportPort struct {
+	rw          io.ReadWriter
+	readTimeout time.Duration
+}
+
+// readDeadliner is implemented by transports that support read timeouts
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+func (p *transportPort) Read(b []byte) (int, error) {
+	if d, ok := p.rw.(readDeadliner); ok && p.readTimeout > 0 {
+		err := d.SetReadDeadline(time.Now().Add(p.readTimeout))
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := p.rw.Read(b)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// serial.Port reports a timeout as no data and no error
+		return n, nil
+	}
+	return n, err
+}
+
+func (p *transportPort) Write(b []byte) (int, error) {
+	return p.rw.Write(b)
+}
+
+func (p *transportPort) SetReadTimeout(t time.Duration) error {
+	p.readTimeout = t
+	return nil
+}
+
+func (p *transportPort) SetMode(mode *serial.Mode) error {
+	return nil
+}
+
+func (p *transportPort) ResetInputBuffer() error {
+	return nil
+}
+
+func (p *transportPort) ResetOutputBuffer() error {
+	return nil
+}
+
+func (p *transportPort) SetDTR(dtr bool) error {
+	return nil
+}
+
+func (p *transportPort) SetRTS(rts bool) error {
+	return nil
+}
+
+func (p *transportPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+
+func (p *transportPort) Close() error {
+	if c, ok := p.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package sabertooth
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipeDevice answers the commands written to the far end of a pipe like a
+// device, replying to Gets with respond, and sends the commands on the
+// returned channel
+func pipeDevice(conn net.Conn, respond func(cmd []byte) []byte) <-chan []byte {
+	cmds := make(chan []byte, 16)
+	go func() {
+		defer close(cmds)
+		buf := make([]byte, 64)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			cmd := append([]byte(nil), buf[:n]...)
+			cmds <- cmd
+			if cmd[1] == CmdGet && respond != nil {
+				_, err = conn.Write(respond(cmd))
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return cmds
+}
+
+func TestWithTransport(t *testing.T) {
+	host, device := net.Pipe()
+	defer host.Close()
+	cmds := pipeDevice(device, telemetryDevice)
+	st, err := NewSabertooth(128, "unused", WithTransport(host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Motor(2, -0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bat, err := st.Battery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bat != 24.5 {
+		t.Errorf("battery %v, want 24.5", bat)
+	}
+	for _, want := range [][]byte{
+		setCommand(128, CmdSetValue, 'M', 2, -1024),
+		getCommand(128, CmdGetBattery, 'M', 1),
+	} {
+		if got := <-cmds; !bytes.Equal(got, want) {
+			t.Errorf("got [% x], want [% x]", got, want)
+		}
+	}
+	// Close leaves the transport to its owner
+	err = st.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Motor(1, 0)
+	if err != nil {
+		t.Errorf("transport closed by Close: %v", err)
+	}
+	device.Close()
+}
+
+func TestWithTransportReadTimeout(t *testing.T) {
+	host, device := net.Pipe()
+	defer host.Close()
+	defer device.Close()
+	pipeDevice(device, nil)
+	st, err := NewSabertooth(128, "unused", WithTransport(host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetReadTimeout(50 * time.Millisecond)
+	start := time.Now()
+	_, err = st.Battery()
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a TimeoutError", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("timed out after %v, want about 50ms", elapsed)
+	}
+}
+
+func TestAppendCommands(t *testing.T) {
+	// Encode a batch of commands for a bridge without a device
+	var buf []byte
+	buf = AppendSetCommand(buf, 128, CmdSetValue, 'M', 1, 2047)
+	buf = AppendGetCommand(buf, 129, CmdGetBattery, 'M', 1)
+	want := []byte{
+		0x80, 0x28, 0x00, 0x28, 0x7f, 0x0f, 0x4d, 0x01, 0x5c,
+		0x81, 0x29, 0x10, 0x3a, 0x4d, 0x01, 0x4e,
+	}
+	if !bytes.Equal(buf, want) {
+		t.Errorf("got [% x], want [% x]", buf, want)
+	}
+}
@@ -0,0 +1,91 @@
+package sabertooth
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// valueRange is the range of values a target accepts
+type valueRange struct {
+	min, max float64
+}
+
+// defaultRanges are the value ranges of the targets that can be set
+var defaultRanges = map[Target]valueRange{
+	Motor: {-1, 1},
+	Power: {-1, 1},
+}
+
+// maxRawValue is the largest magnitude a Set command can carry
+const maxRawValue = 16383
+
+// SetTargetRange sets the range of values SetValue accepts for a target.
+// The defaults are -1 to 1 for Motor and Power; other targets can not be
+// set until a range is given. Values are scaled by 2047, so the range can
+// be at most about -8 to 8.
+func (st *Sabertooth) SetTargetRange(target Target, min, max float64) error {
+	if min > max || math.Abs(min)*maxValue > maxRawValue || math.Abs(max)*maxValue > maxRawValue {
+		return errors.New("invalid range")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.ranges == nil {
+		st.ranges = make(map[Target]valueRange)
+	}
+	st.ranges[target] = valueRange{min, max}
+	return nil
+}
+
+// SetValue sets the value of an output, scaled so that 1 is sent as 2047.
+// The value is checked against the range of the target, see
+// SetTargetRange. For motors use Motor instead, which applies the per
+// motor settings such as inversion and speed limit. Like Motor, setting a
+// motor fails with ErrDisabled or ErrHeartbeatLost while the outputs are
+// disabled or the heartbeat is lost, and is verified with WithVerifyWrites.
+func (st *Sabertooth) SetValue(target Target, number int, value float64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	r, ok := st.ranges[target]
+	if !ok {
+		r, ok = defaultRanges[target]
+	}
+	if !ok {
+		return fmt.Errorf("target %q can not be set", rune(target))
+	}
+	if value < r.min || value > r.max {
+		return errors.New("value out of range")
+	}
+	raw := int16(math.Round(value * maxValue))
+	if target != Motor {
+		return st.set(CmdSetValue, byte(target), byte(number), raw)
+	}
+	err := st.motorAllowed()
+	if err != nil {
+		return err
+	}
+	err = st.set(CmdSetValue, byte(target), byte(number), raw)
+	if err != nil {
+		return err
+	}
+	if st.verifyWrites {
+		return st.verify(byte(target), byte(number), raw)
+	}
+	return nil
+}
+
+// SetDuty sets an output from 0 to 100 percent duty cycle, mapped onto 0
+// to 2047. It is meant for unidirectional outputs such as aux outputs
+// driving LEDs or valves, and power outputs configured for them. Motors
+// are bidirectional and take -2047 to 2047; use Motor for them.
+func (st *Sabertooth) SetDuty(port Target, number int, duty float64) error {
+	if port == Motor {
+		return errors.New("motors are bidirectional, use Motor")
+	}
+	if duty < 0 || duty > 100 {
+		return errors.New("duty out of range")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.set(CmdSetValue, byte(port), byte(number), int16(math.Round(duty/100*maxValue)))
+}
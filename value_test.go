@@ -0,0 +1,106 @@
+package sabertooth
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSetValueRanges(t *testing.T) {
+	st, port := newFake()
+	err := st.SetTargetRange(Aux, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target Target
+		number int
+		value  float64
+		ok     bool
+	}{
+		{Motor, 1, 1, true},
+		{Motor, 1, -1.01, false},
+		{Power, 2, -1, true},
+		{Power, 2, 1.5, false},
+		{Aux, 1, 4, true},
+		{Aux, 1, -0.5, false},
+		{Signal, 1, 0.5, false},
+	}
+	for _, test := range tests {
+		err := st.SetValue(test.target, test.number, test.value)
+		if test.ok != (err == nil) {
+			t.Errorf("SetValue(%q, %d, %v): got error %v", rune(test.target), test.number, test.value, err)
+		}
+	}
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'M', 1, 2047),
+		setCommand(128, CmdSetValue, 'P', 2, -2047),
+		setCommand(128, CmdSetValue, 'A', 1, 4*2047),
+	)
+}
+
+func TestSetTargetRangeInvalid(t *testing.T) {
+	st, _ := newFake()
+	for _, r := range [][2]float64{{1, -1}, {-9, 0}, {0, 8.1}} {
+		if err := st.SetTargetRange(Aux, r[0], r[1]); err == nil {
+			t.Errorf("range %v accepted", r)
+		}
+	}
+}
+
+func TestSetValueMotorGated(t *testing.T) {
+	st, port := newFake()
+	err := st.Disable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(port.written())
+	if err := st.SetValue(Motor, 1, 0.5); err != ErrDisabled {
+		t.Errorf("got %v while disabled, want ErrDisabled", err)
+	}
+	// Only motors are gated
+	if err := st.SetValue(Power, 1, 0.5); err != nil {
+		t.Errorf("setting power while disabled: %v", err)
+	}
+	err = st.Enable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.heartbeatLost = true
+	if err := st.SetValue(Motor, 1, 0.5); err != ErrHeartbeatLost {
+		t.Errorf("got %v with the heartbeat lost, want ErrHeartbeatLost", err)
+	}
+	writes := port.written()[n:]
+	want := setCommand(128, CmdSetValue, 'P', 1, 1024)
+	if len(writes) != 5 || !bytes.Equal(writes[0], want) {
+		t.Errorf("got writes [% x], want the power frame [% x] and the enable frames", writes, want)
+	}
+}
+
+func TestSetDuty(t *testing.T) {
+	st, port := newFake()
+	for _, duty := range []float64{0, 50, 100} {
+		err := st.SetDuty(Aux, 2, duty)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectWrites(t, port,
+		setCommand(128, CmdSetValue, 'A', 2, 0),
+		setCommand(128, CmdSetValue, 'A', 2, 1024),
+		setCommand(128, CmdSetValue, 'A', 2, 2047),
+	)
+}
+
+func TestSetDutyInvalid(t *testing.T) {
+	st, port := newFake()
+	if err := st.SetDuty(Power, 1, -1); err == nil {
+		t.Error("duty -1 accepted")
+	}
+	if err := st.SetDuty(Power, 1, 100.5); err == nil {
+		t.Error("duty 100.5 accepted")
+	}
+	if err := st.SetDuty(Motor, 1, 50); err == nil {
+		t.Error("duty accepted for a motor")
+	}
+	expectWrites(t, port)
+}
@@ -0,0 +1,164 @@
+package sabertooth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// StartWatchdog starts a goroutine that stops the motors with Stop if no
+// Set command, for example Motor, has been sent for longer than timeout.
+// Every Set command restarts the timeout. While the watchdog keeps
+// timing out it repeats Stop every timeout. The watchdog runs until ctx is
+// done. It complements the serial timeout of the controller by also
+// catching a stalled control loop while the host is still sending other
+// commands. timeout must be at least a millisecond.
+func (st *Sabertooth) StartWatchdog(ctx context.Context, timeout time.Duration) error {
+	if timeout < time.Millisecond {
+		return errors.New("invalid timeout")
+	}
+	st.mu.Lock()
+	st.lastCommand = time.Now()
+	st.mu.Unlock()
+	ticks, stop := newTicker(timeout / 4)
+	go st.watchdog(ctx, timeout, ticks, stop)
+	return nil
+}
+
+// newTicker starts a ticker sending the time every d, and returns its
+// channel and a function stopping it. Tests replace it to tick on demand.
+var newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+func (st *Sabertooth) watchdog(ctx context.Context, timeout time.Duration, ticks <-chan time.Time, stop func()) {
+	defer stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticks:
+			st.mu.Lock()
+			if !st.watchdogPaused && now.Sub(st.lastCommand) > timeout {
+				st.logf("watchdog timeout, stopping motors")
+				err := st.stop()
+				if err != nil {
+					st.logf("watchdog stop: %v", err)
+				}
+			}
+			st.mu.Unlock()
+		}
+	}
+}
+
+// PauseWatchdog suspends the watchdog started with StartWatchdog, for
+// example while the motors are deliberately left running without commands
+// during a long calibration. While paused the motors are not stopped
+// automatically.
+func (st *Sabertooth) PauseWatchdog() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.watchdogPaused = true
+}
+
+// ResumeWatchdog resumes the watchdog paused with PauseWatchdog. The
+// timeout starts over from the time of the call.
+func (st *Sabertooth) ResumeWatchdog() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.watchdogPaused = false
+	st.lastCommand = time.Now()
+}
+
+// RequireHeartbeat starts a goroutine that acts as a deadman switch driven
+// by an external signal, such as an enable button on a pendant. If nothing
+// is received on heartbeat for longer than timeout, or heartbeat is
+// closed, the motors are stopped with Stop and Motor, MotorRaw,
+// MixedDrive and SetValue for a motor return ErrHeartbeatLost until the
+// next heartbeat arrives. While the heartbeat is missing Stop is repeated
+// every timeout. Unlike the watchdog it does not depend on the commands
+// the program sends. The heartbeat is no longer required once ctx is done.
+// timeout must be at least a millisecond.
+func (st *Sabertooth) RequireHeartbeat(ctx context.Context, heartbeat <-chan struct{}, timeout time.Duration) error {
+	if timeout < time.Millisecond {
+		return errors.New("invalid timeout")
+	}
+	go st.requireHeartbeat(ctx, heartbeat, timeout)
+	return nil
+}
+
+func (st *Sabertooth) requireHeartbeat(ctx context.Context, heartbeat <-chan struct{}, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			st.mu.Lock()
+			st.heartbeatLost = false
+			st.mu.Unlock()
+			return
+		case _, ok := <-heartbeat:
+			if !ok {
+				// A closed channel never delivers another heartbeat
+				heartbeat = nil
+				continue
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(timeout)
+			st.mu.Lock()
+			if st.heartbeatLost {
+				st.logf("heartbeat resumed")
+				st.heartbeatLost = false
+			}
+			st.mu.Unlock()
+		case <-timer.C:
+			st.mu.Lock()
+			if !st.heartbeatLost {
+				st.logf("heartbeat lost, stopping motors")
+				st.heartbeatLost = true
+			}
+			err := st.stop()
+			if err != nil {
+				st.logf("heartbeat stop: %v", err)
+			}
+			st.mu.Unlock()
+			timer.Reset(timeout)
+		}
+	}
+}
+
+// StartKeepalive starts a goroutine that calls Keepalive every interval
+// until ctx is done, so that the serial timeout of the controller does not
+// stop the motors while the program is idle. interval must be shorter than
+// the serial timeout. The keepalives are sent between other commands and
+// never interrupt them. Failed keepalives are logged and otherwise
+// ignored. interval must be greater than 0.
+func (st *Sabertooth) StartKeepalive(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("invalid interval")
+	}
+	ticks, stop := newTicker(interval)
+	go func() {
+		defer stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticks:
+				err := st.Keepalive()
+				if err != nil {
+					st.mu.Lock()
+					st.logf("keepalive: %v", err)
+					st.mu.Unlock()
+				}
+			}
+		}
+	}()
+	return nil
+}
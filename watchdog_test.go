@@ -0,0 +1,219 @@
+package sabertooth
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// stops counts the frames stopping motor 1 written to port
+func stops(port *fakePort) int {
+	stop := setCommand(128, CmdSetValue, 'M', 1, 0)
+	n := 0
+	for _, w := range port.written() {
+		if bytes.Equal(w, stop) {
+			n++
+		}
+	}
+	return n
+}
+
+// fakeTicker replaces newTicker with a ticker that ticks when a time is
+// sent on ticks. stopped is closed when the ticker is stopped. Call restore
+// when done.
+func fakeTicker() (ticks chan time.Time, stopped chan struct{}, restore func()) {
+	ticks = make(chan time.Time)
+	stopped = make(chan struct{})
+	saved := newTicker
+	newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() { close(stopped) }
+	}
+	return ticks, stopped, func() { newTicker = saved }
+}
+
+func TestWatchdogStopsStalledLoop(t *testing.T) {
+	ticks, stopped, restore := fakeTicker()
+	defer restore()
+	st, port := newFake()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timeout := 40 * time.Millisecond
+	err := st.StartWatchdog(ctx, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		err = st.Motor(1, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ticks <- time.Now()
+	}
+	// Stalled loop
+	ticks <- time.Now().Add(timeout + time.Millisecond)
+	cancel()
+	<-stopped
+	if n := stops(port); n != 1 {
+		t.Fatalf("watchdog stopped the motors %d times, want once for the stalled loop", n)
+	}
+	if speed := st.LastSpeed(1); speed != 0 {
+		t.Errorf("last speed %v after watchdog stop, want 0", speed)
+	}
+}
+
+func TestWatchdogInvalidTimeout(t *testing.T) {
+	st, _ := newFake()
+	for _, timeout := range []time.Duration{-time.Second, 0, 3} {
+		if err := st.StartWatchdog(context.Background(), timeout); err == nil {
+			t.Errorf("timeout %v accepted", timeout)
+		}
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	st, port := newFake()
+	err := st.Keepalive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, port, setCommand(128, CmdSetKeepalive, 'M', '*', 0))
+}
+
+func TestStartKeepalive(t *testing.T) {
+	ticks, stopped, restore := fakeTicker()
+	defer restore()
+	st, port := newFake()
+	ctx, cancel := context.WithCancel(context.Background())
+	err := st.StartKeepalive(ctx, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		ticks <- time.Now()
+	}
+	cancel()
+	<-stopped
+	keepalive := setCommand(128, CmdSetKeepalive, 'M', '*', 0)
+	expectWrites(t, port, keepalive, keepalive, keepalive, keepalive, keepalive)
+}
+
+func TestStartKeepaliveInvalidInterval(t *testing.T) {
+	st, _ := newFake()
+	for _, interval := range []time.Duration{-time.Second, 0} {
+		if err := st.StartKeepalive(context.Background(), interval); err == nil {
+			t.Errorf("interval %v accepted", interval)
+		}
+	}
+}
+
+func TestPauseWatchdog(t *testing.T) {
+	ticks, stopped, restore := fakeTicker()
+	defer restore()
+	st, port := newFake()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timeout := 20 * time.Millisecond
+	err := st.StartWatchdog(ctx, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.PauseWatchdog()
+	ticks <- time.Now().Add(4 * timeout)
+	// The zero time never times out; receiving it means the tick above is done
+	ticks <- time.Time{}
+	st.ResumeWatchdog()
+	// The timeout starts over when the watchdog is resumed
+	ticks <- time.Now()
+	ticks <- time.Now().Add(timeout + time.Millisecond)
+	cancel()
+	<-stopped
+	if n := stops(port); n != 1 {
+		t.Fatalf("watchdog stopped the motors %d times, want once after resuming", n)
+	}
+}
+
+func TestRequireHeartbeat(t *testing.T) {
+	st, port := newFake()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	heartbeat := make(chan struct{})
+	err := st.RequireHeartbeat(ctx, heartbeat, 40*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		heartbeat <- struct{}{}
+		err := st.Motor(1, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := stops(port); n != 0 {
+		t.Fatalf("motors stopped %d times with the heartbeat present", n)
+	}
+
+	// Heartbeat lost
+	time.Sleep(80 * time.Millisecond)
+	if stops(port) == 0 {
+		t.Fatal("motors not stopped when the heartbeat was lost")
+	}
+	n := len(port.written())
+	if err := st.Motor(1, 0.5); err != ErrHeartbeatLost {
+		t.Errorf("Motor: got %v, want ErrHeartbeatLost", err)
+	}
+	if err := st.MotorRaw(1, 1024); err != ErrHeartbeatLost {
+		t.Errorf("MotorRaw: got %v, want ErrHeartbeatLost", err)
+	}
+	if err := st.MixedDrive(0.5, 0); err != ErrHeartbeatLost {
+		t.Errorf("MixedDrive: got %v, want ErrHeartbeatLost", err)
+	}
+	if err := st.SetValue(Motor, 1, 0.5); err != ErrHeartbeatLost {
+		t.Errorf("SetValue: got %v, want ErrHeartbeatLost", err)
+	}
+	for _, w := range port.written()[n:] {
+		if !bytes.Equal(w, setCommand(128, CmdSetValue, 'M', 1, 0)) && !bytes.Equal(w, setCommand(128, CmdSetValue, 'M', 2, 0)) {
+			t.Errorf("wrote [% x] with the heartbeat lost", w)
+		}
+	}
+
+	// Recovered; the heartbeat is handled asynchronously
+	heartbeat <- struct{}{}
+	time.Sleep(5 * time.Millisecond)
+	err = st.Motor(1, 0.5)
+	if err != nil {
+		t.Fatalf("Motor after the heartbeat resumed: %v", err)
+	}
+}
+
+func TestRequireHeartbeatEnds(t *testing.T) {
+	st, _ := newFake()
+	ctx, cancel := context.WithCancel(context.Background())
+	heartbeat := make(chan struct{})
+	close(heartbeat)
+	err := st.RequireHeartbeat(ctx, heartbeat, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := st.Motor(1, 0.5); err != ErrHeartbeatLost {
+		t.Fatalf("got %v with the heartbeat channel closed, want ErrHeartbeatLost", err)
+	}
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if err := st.Motor(1, 0.5); err != nil {
+		t.Errorf("heartbeat still required after ctx is done: %v", err)
+	}
+}
+
+func TestRequireHeartbeatInvalidTimeout(t *testing.T) {
+	st, port := newFake()
+	for _, timeout := range []time.Duration{-time.Second, 0, 3} {
+		if err := st.RequireHeartbeat(context.Background(), nil, timeout); err == nil {
+			t.Errorf("timeout %v accepted", timeout)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	expectWrites(t, port)
+}